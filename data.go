@@ -1,9 +1,12 @@
 package main
 
 import (
+	"database/sql"
 	"time"
 
 	"github.com/cvrs3d/webserv/internal/database"
+	"github.com/cvrs3d/webserv/internal/entities"
+	"github.com/cvrs3d/webserv/internal/passwordpolicy"
 	"github.com/google/uuid"
 )
 
@@ -15,14 +18,168 @@ type User struct {
 	JWTToken  	 string	   `json:"token,omitempty"`
 	RefreshToken string	   `json:"refresh_token,omitempty"`
 	IsChirpyRed	 bool	   `json:"is_chirpy_red"`
+	Role		 string	   `json:"role"`
+	MFAEnabled	 bool	   `json:"mfa_enabled"`
+	EmailVerified bool	   `json:"email_verified"`
+	Handle		 string	   `json:"handle"`
+	DisplayName	 string	   `json:"display_name"`
+	Bio			 string	   `json:"bio"`
+	AvatarURL	 string	   `json:"avatar_url,omitempty"`
+}
+
+// Profile is the public view of a user. It must never carry the email.
+type Profile struct {
+	ID          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type MFAChallenge struct {
+	MFARequired bool `json:"mfa_required"`
+	MFAToken string `json:"mfa_token"`
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
 }
 
 type Chirp struct {
 	ID	uuid.UUID `json:"id"`
-	UserID uuid.UUID `json:"user_id"`
+	UserID *uuid.UUID `json:"user_id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Body string `json:"body"`
+	InReplyTo *uuid.UUID `json:"in_reply_to,omitempty"`
+	ConversationID uuid.UUID `json:"conversation_id"`
+	Edited bool `json:"edited"`
+	Deleted bool `json:"deleted,omitempty"`
+	Entities []entities.Entity `json:"entities"`
+	LikeCount int32 `json:"like_count"`
+	ReactionCounts map[string]int32 `json:"reaction_counts"`
+	LikedByMe bool `json:"liked_by_me"`
+	RechirpOfID *uuid.UUID `json:"rechirp_of_id,omitempty"`
+	RechirpOf *Chirp `json:"rechirp_of,omitempty"`
+	QuoteOfID *uuid.UUID `json:"quote_of_id,omitempty"`
+	QuoteOf *Chirp `json:"quote_of,omitempty"`
+	Author *Profile `json:"author,omitempty"`
+}
+
+type TrendingHashtag struct {
+	Tag string `json:"tag"`
+	Uses int64 `json:"uses"`
+}
+
+type ThreadReply struct {
+	Chirp
+	Depth int32 `json:"depth"`
+}
+
+type SearchHit struct {
+	Chirp
+	Rank float32 `json:"rank"`
+	Snippet string `json:"snippet"`
+}
+
+type SearchPage struct {
+	Chirps []SearchHit `json:"chirps"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type ChirpRevision struct {
+	ID uuid.UUID `json:"id"`
+	ChirpID uuid.UUID `json:"chirp_id"`
+	Body string `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+type Session struct {
+	ID uuid.UUID `json:"id"`
+	UserAgent string `json:"user_agent"`
+	IPAddress string `json:"ip_address"`
+	SignedInAt time.Time `json:"signed_in_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+type AccountDeletion struct {
+	DeletionScheduledFor time.Time `json:"deletion_scheduled_for"`
+}
+
+type DataExport struct {
+	ID uuid.UUID `json:"id"`
+	Status string `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// AccountArchive is the document a data export produces.
+type AccountArchive struct {
+	ExportedAt time.Time `json:"exported_at"`
+	Profile User `json:"profile"`
+	Chirps []Chirp `json:"chirps"`
+	Sessions []Session `json:"sessions"`
+}
+
+type ChirpTooLong struct {
+	Error string `json:"error"`
+	Length int `json:"length"`
+	MaxLength int `json:"max_length"`
+}
+
+type PasswordRejected struct {
+	Error string `json:"error"`
+	Violations []passwordpolicy.Violation `json:"violations"`
+}
+
+type ModerationRule struct {
+	ID uuid.UUID `json:"id"`
+	Pattern string `json:"pattern"`
+	Action string `json:"action"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type HeldChirp struct {
+	ID uuid.UUID `json:"id"`
+	UserID uuid.UUID `json:"user_id"`
+	Body string `json:"body"`
+	InReplyTo *uuid.UUID `json:"in_reply_to,omitempty"`
+	QuoteOf *uuid.UUID `json:"quote_of,omitempty"`
+	Matched string `json:"matched"`
+	Status string `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Thread struct {
+	Chirp Chirp `json:"chirp"`
+	Ancestors []Chirp `json:"ancestors"`
+	Replies []ThreadReply `json:"replies"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type Follow struct {
+	UserID uuid.UUID `json:"user_id"`
+	CreatedAt time.Time `json:"followed_at"`
+}
+
+type FollowPage struct {
+	Users []Follow `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type ChirpPage struct {
+	Chirps []Chirp `json:"chirps"`
+	NextCursor string `json:"next_cursor,omitempty"`
 }
 
 func MapUserDTOToUser(dto database.User) User {
@@ -32,15 +189,173 @@ func MapUserDTOToUser(dto database.User) User {
 		UpdatedAt: dto.UpdatedAt,
 		Email: dto.Email,
 		IsChirpyRed: dto.IsChirpyRed.Bool,
+		Role: dto.Role,
+		MFAEnabled: dto.TotpEnabledAt.Valid,
+		EmailVerified: dto.EmailVerifiedAt.Valid,
+		Handle: dto.Handle,
+		DisplayName: dto.DisplayName,
+		Bio: dto.Bio,
+		AvatarURL: avatarURL(dto.AvatarKey),
+	}
+}
+
+func MapUserDTOToProfile(dto database.User) Profile {
+	return Profile{
+		ID: dto.ID,
+		Handle: dto.Handle,
+		DisplayName: dto.DisplayName,
+		Bio: dto.Bio,
+		AvatarURL: avatarURL(dto.AvatarKey),
+		CreatedAt: dto.CreatedAt,
+	}
+}
+
+// mediaPath is where blobs are served from.
+const mediaPath = "/media/"
+
+func avatarURL(key sql.NullString) string {
+	if !key.Valid {
+		return ""
 	}
+	return mediaPath + key.String
 }
 
 func MapChirpDTOToChirp(dto database.Chirp) Chirp {
-	return Chirp{
+	chirp := Chirp{
 		ID: dto.ID,
-		UserID: dto.UserID,
 		CreatedAt: dto.CreatedAt,
 		UpdatedAt: dto.UpdatedAt,
 		Body: dto.Body,
+		ConversationID: dto.ConversationID,
+		Edited: dto.UpdatedAt.After(dto.CreatedAt),
+		Entities: entities.Extract(dto.Body),
+		LikeCount: dto.LikeCount,
+		ReactionCounts: map[string]int32{},
+	}
+	// the author was purged and the chirp is kept as a tombstone
+	if dto.UserID.Valid {
+		chirp.UserID = &dto.UserID.UUID
+	}
+	if dto.InReplyTo.Valid {
+		chirp.InReplyTo = &dto.InReplyTo.UUID
+	}
+	if dto.RechirpOf.Valid {
+		chirp.RechirpOfID = &dto.RechirpOf.UUID
+	}
+	if dto.QuoteOf.Valid {
+		chirp.QuoteOfID = &dto.QuoteOf.UUID
+	}
+	// deleted chirps with replies are kept as tombstones so the thread stays connected
+	if dto.DeletedAt.Valid {
+		chirp.Deleted = true
+		chirp.Edited = false
+		chirp.Body = ""
+		chirp.Entities = []entities.Entity{}
 	}
-}
\ No newline at end of file
+	return chirp
+}
+
+func MapThreadReplyDTOToThreadReply(dto database.GetChirpDescendantsRow) ThreadReply {
+	return ThreadReply{
+		Chirp: MapChirpDTOToChirp(database.Chirp{
+			ID: dto.ID,
+			UserID: dto.UserID,
+			CreatedAt: dto.CreatedAt,
+			UpdatedAt: dto.UpdatedAt,
+			Body: dto.Body,
+			InReplyTo: dto.InReplyTo,
+			ConversationID: dto.ConversationID,
+			DeletedAt: dto.DeletedAt,
+			LikeCount: dto.LikeCount,
+			RechirpOf: dto.RechirpOf,
+			QuoteOf: dto.QuoteOf,
+		}),
+		Depth: dto.Depth,
+	}
+}
+
+func MapSearchHitDTOToSearchHit(dto database.SearchChirpsRow) SearchHit {
+	return SearchHit{
+		Chirp: MapChirpDTOToChirp(database.Chirp{
+			ID: dto.ID,
+			UserID: dto.UserID,
+			CreatedAt: dto.CreatedAt,
+			UpdatedAt: dto.UpdatedAt,
+			Body: dto.Body,
+			InReplyTo: dto.InReplyTo,
+			ConversationID: dto.ConversationID,
+			DeletedAt: dto.DeletedAt,
+			LikeCount: dto.LikeCount,
+			RechirpOf: dto.RechirpOf,
+			QuoteOf: dto.QuoteOf,
+		}),
+		Rank: dto.Rank,
+		Snippet: dto.Snippet,
+	}
+}
+
+func MapChirpRevisionDTOToChirpRevision(dto database.ChirpRevision) ChirpRevision {
+	return ChirpRevision{
+		ID: dto.ID,
+		ChirpID: dto.ChirpID,
+		Body: dto.Body,
+		CreatedAt: dto.CreatedAt,
+		ReplacedAt: dto.ReplacedAt,
+	}
+}
+
+func MapModerationRuleDTOToModerationRule(dto database.ModerationRule) ModerationRule {
+	return ModerationRule{
+		ID: dto.ID,
+		Pattern: dto.Pattern,
+		Action: dto.Action,
+		CreatedAt: dto.CreatedAt,
+		UpdatedAt: dto.UpdatedAt,
+	}
+}
+
+func MapHeldChirpDTOToHeldChirp(dto database.HeldChirp) HeldChirp {
+	held := HeldChirp{
+		ID: dto.ID,
+		UserID: dto.UserID,
+		Body: dto.Body,
+		Matched: dto.Matched,
+		Status: "held_for_review",
+		CreatedAt: dto.CreatedAt,
+	}
+	if dto.InReplyTo.Valid {
+		held.InReplyTo = &dto.InReplyTo.UUID
+	}
+	if dto.QuoteOf.Valid {
+		held.QuoteOf = &dto.QuoteOf.UUID
+	}
+	return held
+}
+
+func MapSessionDTOToSession(dto database.ListSessionsRow) Session {
+	return Session{
+		ID: dto.FamilyID,
+		UserAgent: dto.UserAgent,
+		IPAddress: dto.IpAddress,
+		SignedInAt: dto.SignedInAt,
+		LastUsedAt: dto.LastUsedAt,
+	}
+}
+
+func MapDataExportDTOToDataExport(dto database.DataExport) DataExport {
+	export := DataExport{
+		ID: dto.ID,
+		Status: dto.Status,
+		CreatedAt: dto.CreatedAt,
+	}
+	if dto.CompletedAt.Valid {
+		export.CompletedAt = &dto.CompletedAt.Time
+	}
+	if dto.ExpiresAt.Valid {
+		export.ExpiresAt = &dto.ExpiresAt.Time
+	}
+	if dto.Status == dataExportReady {
+		export.DownloadURL = "/api/users/me/exports/" + dto.ID.String() + "/download"
+	}
+	return export
+}
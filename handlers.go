@@ -1,12 +1,32 @@
 package main
 
 import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
+	"net"
 	"net/http"
-	"slices"
+	"net/netip"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cvrs3d/webserv/internal/auth"
+	"github.com/cvrs3d/webserv/internal/database"
+	"github.com/cvrs3d/webserv/internal/mail"
+	"github.com/cvrs3d/webserv/internal/pagination"
+	"github.com/cvrs3d/webserv/internal/textlen"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 
@@ -46,18 +66,358 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(data)
 }
 
-func cleanBody(b string) string {
-	profane_words := []string{"kerfuffle", "sharbert", "fornax"}
-	lowerString := strings.ToLower(b)
-	words := strings.Fields(lowerString)
-	normalWords := strings.Fields(b)
-	out := make([]string, len(words))
-	for i, word := range words {
-		if slices.Contains(profane_words, word) {
-			out[i] = "****"
+// clientIP is the address the request came from. X-Forwarded-For is only
+// believed when the connection comes from one of trustedProxies, and then the
+// right-most address that isn't a trusted proxy wins, since everything left
+// of it could have been made up by the client.
+func clientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host, trustedProxies) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		if !trustedProxy(hop, trustedProxies) {
+			return hop
+		}
+	}
+	return host
+}
+
+func trustedProxy(ip string, trustedProxies []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueViolation reports whether err is Postgres rejecting a duplicate
+// value, and names the unique constraint or index that was violated.
+func uniqueViolation(err error) (string, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return pqErr.Constraint, true
+	}
+	return "", false
+}
+
+// Unique constraints on users, as named by Postgres.
+const (
+	usersEmailKey = "users_email_key"
+	usersHandleIndex = "users_handle_lower_idx"
+)
+
+// respondUserConflict answers 409 naming the taken field when err is a
+// duplicate email or handle, and returns false for any other error.
+func respondUserConflict(w http.ResponseWriter, err error) bool {
+	constraint, ok := uniqueViolation(err)
+	if !ok {
+		return false
+	}
+	switch constraint {
+	case usersHandleIndex:
+		respondWithError(w, 409, "Handle is already taken")
+	default:
+		respondWithError(w, 409, "Email is already in use")
+	}
+	return true
+}
+
+const (
+	minHandleLength = 3
+	maxHandleLength = 30
+	maxDisplayNameLength = 50
+	maxBioLength = 160
+)
+
+// reservedHandles would clash with fixed routes under /api/users.
+var reservedHandles = map[string]bool{"me": true}
+
+// validHandle accepts 3 to 30 ASCII letters, digits and underscores.
+func validHandle(handle string) bool {
+	if len(handle) < minHandleLength || len(handle) > maxHandleLength {
+		return false
+	}
+	if reservedHandles[strings.ToLower(handle)] {
+		return false
+	}
+	for _, r := range handle {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// generateHandle makes a placeholder handle for users who sign up without
+// choosing one.
+func generateHandle() (string, error) {
+	b := make([]byte, 5)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "user_" + hex.EncodeToString(b), nil
+}
+
+// expandAuthor reports whether the client asked for chirp authors to be
+// embedded with ?expand=author.
+func expandAuthor(r *http.Request) bool {
+	for _, v := range r.URL.Query()["expand"] {
+		for _, field := range strings.Split(v, ",") {
+			if strings.TrimSpace(field) == "author" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// avatarTypes maps the image types accepted as avatars to file extensions.
+var avatarTypes = map[string]string{
+	"image/png": ".png",
+	"image/jpeg": ".jpg",
+	"image/gif": ".gif",
+	"image/webp": ".webp",
+}
+
+// respondTooManyRequests answers 429 with a Retry-After of the whole seconds
+// left until until.
+func respondTooManyRequests(w http.ResponseWriter, until time.Time, msg string) {
+	seconds := int(math.Ceil(time.Until(until).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondWithError(w, 429, msg)
+}
+
+var dummyHash struct {
+	once sync.Once
+	hash string
+}
+
+// dummyPasswordHash is compared against when a login names no account, so
+// that the response takes as long as a wrong password would. params should
+// be the ones real hashes are made with.
+func dummyPasswordHash(params *auth.PasswordParams) string {
+	dummyHash.once.Do(func() {
+		hash, err := auth.HashPasswordWithParams("not a real password", params)
+		if err != nil {
+			log.Printf("Error hashing dummy password: %s", err)
+			return
+		}
+		dummyHash.hash = hash
+	})
+	return dummyHash.hash
+}
+
+// checkChirpLength measures body for the author's tier and responds with a
+// 422 carrying the measured length and the maximum when it is too long.
+func checkChirpLength(w http.ResponseWriter, body string, isChirpyRed bool) bool {
+	length := textlen.Weighted(body)
+	limit := textlen.Limit(isChirpyRed)
+	if length <= limit {
+		return true
+	}
+	respondWithJSON(w, 422, ChirpTooLong{
+		Error: "Chirp is too long",
+		Length: length,
+		MaxLength: limit,
+	})
+	return false
+}
+
+type pageParams struct {
+	limit int
+	cursorRank sql.NullFloat64
+	cursorCreatedAt sql.NullTime
+	cursorID uuid.NullUUID
+}
+
+// errInvalidCursor wraps cursor decoding errors, which describe the base64
+// and JSON internals and are only logged.
+var errInvalidCursor = errors.New("invalid cursor")
+
+func parsePageParams(query url.Values) (pageParams, error) {
+	limit, err := pagination.ParseLimit(query.Get("limit"))
+	if err != nil {
+		return pageParams{}, err
+	}
+	page := pageParams{limit: limit}
+	if c := query.Get("cursor"); c != "" {
+		cursor, err := pagination.DecodeCursor(c)
+		if err != nil {
+			return pageParams{}, fmt.Errorf("%w: %w", errInvalidCursor, err)
+		}
+		if cursor.Rank != nil {
+			page.cursorRank = sql.NullFloat64{Float64: float64(*cursor.Rank), Valid: true}
+		}
+		page.cursorCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		page.cursorID = uuid.NullUUID{UUID: cursor.ID, Valid: true}
+	}
+	return page, nil
+}
+
+// respondPageError answers 400 for the error from parsePageParams. Limit
+// errors are safe to echo; a bad cursor gets a fixed message.
+func respondPageError(w http.ResponseWriter, err error) {
+	log.Printf("Error parsing page parameters: %s", err)
+	if errors.Is(err, errInvalidCursor) {
+		respondWithError(w, 400, "Invalid cursor")
+		return
+	}
+	respondWithError(w, 400, err.Error())
+}
+
+// setNextCursor sets the Link header and returns the cursor for the row the page ended on.
+func setNextCursor(w http.ResponseWriter, r *http.Request, cursor pagination.Cursor) string {
+	next := cursor.Encode()
+	w.Header().Set("Link", pagination.NextLink(r.URL, next))
+	return next
+}
+
+// validEmoji accepts one emoji, including skin tone modifiers, flags and ZWJ
+// sequences. Other symbols such as © or arrows are rejected, and so is more
+// than one emoji.
+func validEmoji(s string) bool {
+	if s == "" || len(s) > 32 || !utf8.ValidString(s) || textlen.Graphemes(s) != 1 {
+		return false
+	}
+	for _, r := range s {
+		switch {
+		case r >= 0x1F000 && r <= 0x1FAFF: // pictographs, flags and skin tones
+		case r >= 0x2600 && r <= 0x27BF: // miscellaneous symbols and dingbats
+		case r == 0x2B50 || r == 0x2B55: // star and circle
+		case r == 0x200D: // zero width joiner
+		case r == 0xFE0F: // emoji presentation selector
+		case r >= 0xE0020 && r <= 0xE007F: // tags of subdivision flags
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// tokenLink appends token to baseURL as the token query parameter.
+func tokenLink(baseURL, token string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return baseURL + "?token=" + url.QueryEscape(token)
+	}
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// passwordResetMessage builds the email carrying a reset link.
+func passwordResetMessage(to, baseURL, token string, ttl time.Duration) mail.Message {
+	return mail.Message{
+		To: to,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password for your Chirpy account.\n\n"+
+				"Open this link within %d minutes to choose a new one:\n\n%s\n\n"+
+				"If it wasn't you, you can ignore this email.\n",
+			int(ttl.Minutes()), tokenLink(baseURL, token)),
+	}
+}
+
+// verificationMessage builds the email confirming that to belongs to the
+// account holder.
+func verificationMessage(to, baseURL, token string, ttl time.Duration) mail.Message {
+	return mail.Message{
+		To: to,
+		Subject: "Confirm your Chirpy email address",
+		Body: fmt.Sprintf(
+			"Welcome to Chirpy!\n\n"+
+				"Open this link within %d hours to confirm your email address:\n\n%s\n\n"+
+				"If you didn't sign up, you can ignore this email.\n",
+			int(ttl.Hours()), tokenLink(baseURL, token)),
+	}
+}
+
+// deletionMessage builds the email confirming that an account is scheduled
+// for deletion.
+func deletionMessage(to string, purgeAfter time.Time) mail.Message {
+	return mail.Message{
+		To: to,
+		Subject: "Your Chirpy account will be deleted",
+		Body: fmt.Sprintf(
+			"Your Chirpy account is scheduled for deletion on %s.\n\n"+
+				"Until then you can cancel by signing in again. After that date your "+
+				"profile, chirps and all other data are removed for good.\n",
+			purgeAfter.UTC().Format("January 2, 2006 15:04 MST")),
+	}
+}
+
+// purgedChirps splits the chirps of a purged user into the ones that can be
+// deleted and the ones kept as tombstones. A chirp is kept when a chirp by
+// someone else replies to, quotes or rechirps it, or when a kept chirp does,
+// so threads stay connected once the author is gone.
+func purgedChirps(chirps []database.Chirp, referenced []uuid.UUID) (drop, keep []uuid.UUID) {
+	byID := make(map[uuid.UUID]database.Chirp, len(chirps))
+	for _, c := range chirps {
+		byID[c.ID] = c
+	}
+	kept := make(map[uuid.UUID]bool, len(referenced))
+	queue := []uuid.UUID{}
+	for _, id := range referenced {
+		if !kept[id] {
+			kept[id] = true
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		c, ok := byID[queue[0]]
+		queue = queue[1:]
+		if !ok {
+			continue
+		}
+		for _, ref := range []uuid.NullUUID{c.InReplyTo, c.QuoteOf, c.RechirpOf} {
+			if ref.Valid && !kept[ref.UUID] {
+				kept[ref.UUID] = true
+				queue = append(queue, ref.UUID)
+			}
+		}
+	}
+
+	for _, c := range chirps {
+		if kept[c.ID] {
+			keep = append(keep, c.ID)
 		} else {
-			out[i] = normalWords[i]
+			drop = append(drop, c.ID)
 		}
 	}
-	return strings.Join(out, " ")
-}
\ No newline at end of file
+	return drop, keep
+}
+
+// unlockMessage builds the email sent when an account is locked after too
+// many failed logins.
+func unlockMessage(to, baseURL, token string, ttl time.Duration) mail.Message {
+	return mail.Message{
+		To: to,
+		Subject: "Your Chirpy account was locked",
+		Body: fmt.Sprintf(
+			"There were too many failed attempts to sign in to your Chirpy account, "+
+				"so it is locked for a while.\n\n"+
+				"If that was you, open this link within %d hours to unlock it now:\n\n%s\n\n"+
+				"If it wasn't you, consider resetting your password.\n",
+			int(ttl.Hours()), tokenLink(baseURL, token)),
+	}
+}
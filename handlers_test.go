@@ -2,44 +2,256 @@ package main
 
 
 import (
+	"database/sql"
+	"fmt"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/cvrs3d/webserv/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
-func TestCleanBody(t *testing.T) {
+func TestValidEmoji(t *testing.T) {
 	tests := []struct {
 		name string
 		in   string
-		want string
+		want bool
 	}{
-		{
-			name: "no profane words",
-			in:   "Hello world",
-			want: "Hello world",
-		},
-		{
-			name: "censors profane words and lowercases",
-			in:   "Kerfuffle is bad",
-			want: "**** is bad",
-		},
-		{
-			name: "multiple profane words",
-			in:   "sharbert fornax ok",
-			want: "**** **** ok",
-		},
-		{
-			name: "handles extra spaces",
-			in:   "  hello   kerfuffle  ",
-			want: "hello ****",
-		},
+		{name: "simple", in: "🔥", want: true},
+		{name: "skin tone", in: "👍🏽", want: true},
+		{name: "zwj sequence", in: "👩‍💻", want: true},
+		{name: "flag", in: "🇺🇦", want: true},
+		{name: "empty", in: "", want: false},
+		{name: "text", in: "lol", want: false},
+		{name: "emoji with text", in: "🔥fire", want: false},
+		{name: "several emoji", in: "👍👍👍", want: false},
+		{name: "two flags", in: "🇺🇦🇵🇱", want: false},
+		{name: "copyright sign", in: "©", want: false},
+		{name: "arrow", in: "→", want: false},
+		{name: "box drawing", in: "╬", want: false},
+		{name: "dingbat", in: "✅", want: true},
+		{name: "subdivision flag", in: "🏴\U000E0067\U000E0062\U000E0065\U000E006E\U000E0067\U000E007F", want: true},
 	}
 
 	for _, tc := range tests {
-		tc := tc // capture range var
+		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			got := cleanBody(tc.in)
-			if got != tc.want {
-				t.Fatalf("cleanBody(%q) = %q, want %q", tc.in, got, tc.want)
+			if got := validEmoji(tc.in); got != tc.want {
+				t.Fatalf("validEmoji(%q) = %v, want %v", tc.in, got, tc.want)
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestPasswordResetMessage(t *testing.T) {
+	msg := passwordResetMessage("walt@example.com", "https://chirpy.example/reset?lang=en", "abc123", time.Hour)
+	if msg.To != "walt@example.com" {
+		t.Fatalf("To = %q", msg.To)
+	}
+	if !strings.Contains(msg.Body, "https://chirpy.example/reset?lang=en&token=abc123") {
+		t.Fatalf("body is missing the reset link:\n%s", msg.Body)
+	}
+	if !strings.Contains(msg.Body, "60 minutes") {
+		t.Fatalf("body is missing the expiry:\n%s", msg.Body)
+	}
+}
+
+func TestVerificationMessage(t *testing.T) {
+	msg := verificationMessage("walt@example.com", "http://localhost:8080/api/verify-email", "a b", 24*time.Hour)
+	if !strings.Contains(msg.Body, "http://localhost:8080/api/verify-email?token=a+b") {
+		t.Fatalf("body is missing the verification link:\n%s", msg.Body)
+	}
+	if !strings.Contains(msg.Body, "24 hours") {
+		t.Fatalf("body is missing the expiry:\n%s", msg.Body)
+	}
+}
+
+func TestDeletionMessage(t *testing.T) {
+	purgeAfter := time.Date(2026, time.March, 4, 9, 30, 0, 0, time.UTC)
+	msg := deletionMessage("walt@example.com", purgeAfter)
+	if msg.To != "walt@example.com" {
+		t.Fatalf("To = %q, want walt@example.com", msg.To)
+	}
+	if !strings.Contains(msg.Body, "March 4, 2026 09:30 UTC") {
+		t.Fatalf("body is missing the deletion date:\n%s", msg.Body)
+	}
+}
+
+func TestRespondTooManyRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+	respondTooManyRequests(w, time.Now().Add(90*time.Second+200*time.Millisecond), "slow down")
+	if w.Code != 429 {
+		t.Fatalf("code = %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "91" {
+		t.Fatalf("Retry-After = %q, want 91", got)
+	}
+
+	w = httptest.NewRecorder()
+	respondTooManyRequests(w, time.Now().Add(-time.Second), "slow down")
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Fatalf("Retry-After = %q, want 1 for a block that just ended", got)
+	}
+}
+
+func TestRespondPageError(t *testing.T) {
+	tests := []struct {
+		query url.Values
+		want  string
+	}{
+		{query: url.Values{"cursor": {"%%%"}}, want: `{"error":"Invalid cursor"}`},
+		{query: url.Values{"cursor": {"bm9wZQ"}}, want: `{"error":"Invalid cursor"}`},
+		{query: url.Values{"limit": {"-1"}}, want: `{"error":"limit must be positive, got -1"}`},
+	}
+	for _, tt := range tests {
+		_, err := parsePageParams(tt.query)
+		if err == nil {
+			t.Fatalf("parsePageParams(%v) succeeded", tt.query)
+		}
+		w := httptest.NewRecorder()
+		respondPageError(w, err)
+		if w.Code != 400 || w.Body.String() != tt.want {
+			t.Errorf("%v: got %d %s, want 400 %s", tt.query, w.Code, w.Body.String(), tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:5000", want: "203.0.113.7"},
+		{name: "forwarded by untrusted peer", remoteAddr: "203.0.113.7:5000", forwarded: []string{"198.51.100.1"}, want: "203.0.113.7"},
+		{name: "forwarded by trusted proxy", remoteAddr: "10.0.0.2:5000", forwarded: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "spoofed left-most hop", remoteAddr: "10.0.0.2:5000", forwarded: []string{"1.2.3.4, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "chained proxies", remoteAddr: "10.0.0.2:5000", forwarded: []string{"198.51.100.1", "10.0.0.3"}, want: "198.51.100.1"},
+		{name: "garbage hop", remoteAddr: "10.0.0.2:5000", forwarded: []string{"not-an-ip"}, want: "10.0.0.2"},
+		{name: "proxy without header", remoteAddr: "10.0.0.2:5000", want: "10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r, trusted); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniqueViolation(t *testing.T) {
+	constraint, ok := uniqueViolation(fmt.Errorf("creating user: %w", &pq.Error{Code: "23505", Constraint: usersHandleIndex}))
+	if !ok || constraint != usersHandleIndex {
+		t.Fatalf("got (%q, %v), want the wrapped handle violation", constraint, ok)
+	}
+	if _, ok := uniqueViolation(&pq.Error{Code: "23503"}); ok {
+		t.Fatal("a foreign key violation is not a unique violation")
+	}
+	if _, ok := uniqueViolation(sql.ErrNoRows); ok {
+		t.Fatal("other errors are not unique violations")
+	}
+	if _, ok := uniqueViolation(nil); ok {
+		t.Fatal("nil is not a unique violation")
+	}
+}
+
+func TestValidHandle(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{in: "walt", want: true},
+		{in: "Walter_White_99", want: true},
+		{in: "abc", want: true},
+		{in: "ab", want: false},
+		{in: strings.Repeat("a", 30), want: true},
+		{in: strings.Repeat("a", 31), want: false},
+		{in: "walt.white", want: false},
+		{in: "wálter", want: false},
+		{in: "me", want: false},
+		{in: "", want: false},
+	}
+
+	for _, tc := range tests {
+		if got := validHandle(tc.in); got != tc.want {
+			t.Errorf("validHandle(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+
+	generated, err := generateHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validHandle(generated) {
+		t.Fatalf("generated handle %q is not valid", generated)
+	}
+}
+
+func TestExpandAuthor(t *testing.T) {
+	for target, want := range map[string]bool{
+		"/api/chirps":                          false,
+		"/api/chirps?expand=author":            true,
+		"/api/chirps?expand=replies,+author":   true,
+		"/api/chirps?expand=replies&expand=author": true,
+		"/api/chirps?expand=authors":           false,
+	} {
+		if got := expandAuthor(httptest.NewRequest("GET", target, nil)); got != want {
+			t.Errorf("expandAuthor(%q) = %v, want %v", target, got, want)
+		}
+	}
+}
+
+func TestPurgedChirps(t *testing.T) {
+	id := func() uuid.UUID { return uuid.New() }
+	ref := func(u uuid.UUID) uuid.NullUUID { return uuid.NullUUID{UUID: u, Valid: true} }
+
+	root, reply, quoted, lonely, ownReply, rechirp := id(), id(), id(), id(), id(), id()
+	chirps := []database.Chirp{
+		{ID: root},
+		// replies to the user's own root, and someone else replies to it
+		{ID: reply, InReplyTo: ref(root)},
+		{ID: quoted},
+		{ID: lonely},
+		// only the user's own chirps point at this one
+		{ID: ownReply, InReplyTo: ref(lonely)},
+		{ID: rechirp, RechirpOf: ref(id())},
+	}
+
+	drop, keep := purgedChirps(chirps, []uuid.UUID{reply, quoted, quoted})
+
+	in := func(ids []uuid.UUID, want uuid.UUID) bool {
+		for _, got := range ids {
+			if got == want {
+				return true
+			}
+		}
+		return false
+	}
+	for _, want := range []uuid.UUID{root, reply, quoted} {
+		if !in(keep, want) || in(drop, want) {
+			t.Errorf("chirp %s should be kept as a tombstone", want)
+		}
+	}
+	for _, want := range []uuid.UUID{lonely, ownReply, rechirp} {
+		if !in(drop, want) || in(keep, want) {
+			t.Errorf("chirp %s should be deleted", want)
+		}
+	}
+	if len(keep)+len(drop) != len(chirps) {
+		t.Errorf("got %d kept and %d deleted, want %d chirps in total", len(keep), len(drop), len(chirps))
+	}
+}
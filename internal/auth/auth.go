@@ -8,8 +8,17 @@ import (
 	"github.com/alexedwards/argon2id"
 )
 
+// PasswordParams are the argon2id cost parameters for new password hashes.
+type PasswordParams = argon2id.Params
+
+var DefaultPasswordParams = argon2id.DefaultParams
+
 func HashPassword(password string) (string, error) {
-	hash, err := argon2id.CreateHash(password, argon2id.DefaultParams)
+	return HashPasswordWithParams(password, DefaultPasswordParams)
+}
+
+func HashPasswordWithParams(password string, params *PasswordParams) (string, error) {
+	hash, err := argon2id.CreateHash(password, params)
 	if err != nil {
 		return "", err
 	}
@@ -17,6 +26,20 @@ func HashPassword(password string) (string, error) {
 	return hash, nil
 }
 
+// NeedsRehash reports whether hash was made with less memory, fewer
+// iterations or a shorter salt or key than params asks for. Parallelism is
+// left out: it only changes how the work is spread over threads.
+func NeedsRehash(hash string, params *PasswordParams) (bool, error) {
+	stored, _, _, err := argon2id.DecodeHash(hash)
+	if err != nil {
+		return false, err
+	}
+	return stored.Memory < params.Memory ||
+		stored.Iterations < params.Iterations ||
+		stored.SaltLength < params.SaltLength ||
+		stored.KeyLength < params.KeyLength, nil
+}
+
 func CheckPasswordHash(password, hash string) (bool, error) {
 	match, err := argon2id.ComparePasswordAndHash(password, hash)
 	if err != nil {
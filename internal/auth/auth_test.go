@@ -3,9 +3,11 @@ package auth
 import (
 	"log"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 )
@@ -45,48 +47,167 @@ func TestHashingPasswords(t *testing.T) {
 	}
 }
 
+func TestNeedsRehash(t *testing.T) {
+	weak := &PasswordParams{Memory: 8 * 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	strong := &PasswordParams{Memory: 16 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	hash, err := HashPasswordWithParams("correct horse", weak)
+	require.NoError(t, err)
+
+	rehash, err := NeedsRehash(hash, strong)
+	require.NoError(t, err)
+	require.True(t, rehash, "a hash with less memory and iterations should be upgraded")
+
+	rehash, err = NeedsRehash(hash, weak)
+	require.NoError(t, err)
+	require.False(t, rehash)
+
+	morelanes := *weak
+	morelanes.Parallelism = 4
+	rehash, err = NeedsRehash(hash, &morelanes)
+	require.NoError(t, err)
+	require.False(t, rehash, "parallelism alone doesn't make a hash weaker")
+
+	strongHash, err := HashPasswordWithParams("correct horse", strong)
+	require.NoError(t, err)
+	rehash, err = NeedsRehash(strongHash, weak)
+	require.NoError(t, err)
+	require.False(t, rehash, "stronger hashes are never downgraded")
+
+	_, err = NeedsRehash("not a hash", weak)
+	require.Error(t, err)
+}
+
 func TestMakeAndValidateJWT_Success(t *testing.T) {
-    secret := "super-secret-key-123"
+    secret := newTestKeyring(t)
     userID := uuid.New()
     expiresIn := time.Minute * 10
 
-    token, err := MakeJWT(userID, secret, expiresIn)
+    token, err := MakeJWT(Principal{UserID: userID}, secret, expiresIn)
     require.NoError(t, err, "MakeJWT should not error")
 
-    gotUserID, err := ValidateJWT(token, secret)
+    got, err := ValidateJWT(token, secret, nil)
     require.NoError(t, err, "ValidateJWT should succeed")
-    require.Equal(t, userID, gotUserID, "ValidateJWT should return the same userID that was signed")
+    require.Equal(t, userID, got.UserID, "ValidateJWT should return the same userID that was signed")
 }
 
 func TestValidateJWT_WrongSecret(t *testing.T) {
-    secret := "super-secret-key-123"
-    wrongSecret := "wrong-key"
+    secret := newTestKeyring(t)
+    wrongSecret := newTestKeyring(t)
     userID := uuid.New()
     expiresIn := time.Minute * 10
 
-    token, err := MakeJWT(userID, secret, expiresIn)
+    token, err := MakeJWT(Principal{UserID: userID}, secret, expiresIn)
     require.NoError(t, err)
 
-    _, err = ValidateJWT(token, wrongSecret)
+    _, err = ValidateJWT(token, wrongSecret, nil)
     require.Error(t, err, "ValidateJWT should error if secret is wrong")
 }
 
 func TestValidateJWT_ExpiredToken(t *testing.T) {
-    secret := "super-secret-key-123"
+    secret := newTestKeyring(t)
     userID := uuid.New()
     // expire immediately
     expiresIn := time.Millisecond * 1
 
-    token, err := MakeJWT(userID, secret, expiresIn)
+    token, err := MakeJWT(Principal{UserID: userID}, secret, expiresIn)
     require.NoError(t, err)
 
     // wait for it to expire
     time.Sleep(time.Millisecond * 5)
 
-    _, err = ValidateJWT(token, secret)
+    _, err = ValidateJWT(token, secret, nil)
     require.Error(t, err, "ValidateJWT should error for expired token")
 }
 
+func TestValidateJWT_TokenVersion(t *testing.T) {
+    secret := newTestKeyring(t)
+    userID := uuid.New()
+
+    token, err := MakeJWT(Principal{UserID: userID, TokenVersion: 3}, secret, time.Minute)
+    require.NoError(t, err)
+
+    got, err := ValidateJWT(token, secret, func(uuid.UUID) (int32, error) { return 3, nil })
+    require.NoError(t, err, "ValidateJWT should accept the current version")
+    require.Equal(t, userID, got.UserID)
+
+    _, err = ValidateJWT(token, secret, func(uuid.UUID) (int32, error) { return 4, nil })
+    require.Error(t, err, "ValidateJWT should reject tokens from before a version bump")
+}
+
+func TestValidateJWT_Principal(t *testing.T) {
+    secret := newTestKeyring(t)
+    want := Principal{
+        UserID:       uuid.New(),
+        Scopes:       []string{ScopeChirpsRead, ScopeChirpsWrite},
+        Roles:        []string{RoleUser},
+        TokenVersion: 2,
+        SessionID:    uuid.New(),
+    }
+
+    token, err := MakeJWT(want, secret, time.Minute)
+    require.NoError(t, err)
+
+    got, err := ValidateJWT(token, secret, nil)
+    require.NoError(t, err)
+    require.Equal(t, want, got)
+    require.True(t, got.HasScope(ScopeChirpsWrite))
+    require.False(t, got.HasScope(ScopeAccountWrite))
+    require.True(t, got.HasRole(RoleUser))
+}
+
+func TestValidateJWT_IssuerAndAudience(t *testing.T) {
+    secret := newTestKeyring(t)
+    claims := jwt.RegisteredClaims{
+        Issuer:    Issuer,
+        Audience:  jwt.ClaimStrings{"someone-else"},
+        Subject:   uuid.NewString(),
+        ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+    }
+
+    token, err := secret.sign(claims)
+    require.NoError(t, err)
+    _, err = ValidateJWT(token, secret, nil)
+    require.Error(t, err, "ValidateJWT should reject a foreign audience")
+
+    claims.Audience = jwt.ClaimStrings{Audience}
+    claims.Issuer = "not-chirpy"
+    token, err = secret.sign(claims)
+    require.NoError(t, err)
+    _, err = ValidateJWT(token, secret, nil)
+    require.Error(t, err, "ValidateJWT should reject a foreign issuer")
+}
+
+func TestPrincipalCan(t *testing.T) {
+    user := Principal{Roles: []string{RoleUser}}
+    moderator := Principal{Roles: []string{RoleModerator}}
+    admin := Principal{Roles: []string{RoleAdmin}}
+
+    require.False(t, user.Can(PermViewMetrics))
+    require.True(t, moderator.Can(PermModerate))
+    require.False(t, moderator.Can(PermResetData))
+    require.False(t, moderator.Can(PermManageRoles))
+    require.True(t, admin.Can(PermResetData))
+    require.True(t, admin.Can(PermManageRoles))
+    require.False(t, Principal{Roles: []string{"root"}}.Can(PermViewMetrics))
+
+    require.True(t, ValidRole(RoleModerator))
+    require.False(t, ValidRole("root"))
+}
+
+func TestParseScopes(t *testing.T) {
+    scopes, err := ParseScopes("")
+    require.NoError(t, err)
+    require.Equal(t, AllScopes, scopes)
+
+    scopes, err = ParseScopes(" chirps:read  chirps:read chirps:write ")
+    require.NoError(t, err)
+    require.Equal(t, []string{ScopeChirpsRead, ScopeChirpsWrite}, scopes)
+
+    _, err = ParseScopes("chirps:read admin:everything")
+    require.Error(t, err)
+}
+
 func TestGetBearerToken(t *testing.T) {
     tests := []struct {
         name        string
@@ -167,4 +288,60 @@ func TestGetBearerToken(t *testing.T) {
             }
         })
     }
-}
\ No newline at end of file
+}
+func TestHashRefreshToken(t *testing.T) {
+	token, err := MakeRefreshToken()
+	require.NoError(t, err)
+
+	prefix, hash := HashRefreshToken(token, []byte("key"))
+	require.Equal(t, token[:RefreshTokenPrefixLen], prefix)
+	require.Len(t, hash, 64)
+	require.NotContains(t, hash, token)
+
+	_, again := HashRefreshToken(token, []byte("key"))
+	require.Equal(t, hash, again, "hashing must be deterministic so rows can be looked up")
+
+	_, otherKey := HashRefreshToken(token, []byte("other"))
+	require.NotEqual(t, hash, otherKey)
+
+	shortPrefix, _ := HashRefreshToken("abc", []byte("key"))
+	require.Equal(t, "abc", shortPrefix)
+}
+
+func TestMFAChallenge(t *testing.T) {
+	keys := newTestKeyring(t)
+	userID := uuid.New()
+
+	challenge, err := MakeMFAChallenge(userID, []string{ScopeChirpsRead}, keys, time.Minute)
+	require.NoError(t, err)
+
+	got, err := ValidateMFAChallenge(challenge, keys)
+	require.NoError(t, err)
+	require.Equal(t, userID, got.UserID)
+	require.Equal(t, []string{ScopeChirpsRead}, got.Scopes)
+
+	_, err = ValidateJWT(challenge, keys, nil)
+	require.Error(t, err, "a challenge token must not work as an access token")
+
+	access, err := MakeJWT(Principal{UserID: userID}, keys, time.Minute)
+	require.NoError(t, err)
+	_, err = ValidateMFAChallenge(access, keys)
+	require.Error(t, err, "an access token must not pass as a challenge")
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := MakeRecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		require.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`, code)
+		require.False(t, seen[code])
+		seen[code] = true
+	}
+
+	hash := HashRecoveryCode(codes[0])
+	require.Equal(t, hash, HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(codes[0], "-", " "))))
+	require.NotEqual(t, hash, HashRecoveryCode(codes[1]))
+}
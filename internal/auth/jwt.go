@@ -2,25 +2,34 @@ package auth
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-    // convert secret to []byte explicitly
-    secretKey := []byte(tokenSecret)
-
-    claims := jwt.RegisteredClaims{
-        Issuer:    "chirpy",
-        IssuedAt:  jwt.NewNumericDate(time.Now()),
-        ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
-        Subject:   userID.String(),
+// MakeJWT signs an access token for principal with the keyring's signing
+// key. The principal's token version is embedded so that bumping it on the
+// user invalidates the token.
+func MakeJWT(principal Principal, keys *Keyring, expiresIn time.Duration) (string, error) {
+    claims := MyCustomClaims{
+        RegisteredClaims: jwt.RegisteredClaims{
+            Issuer:    Issuer,
+            Audience:  jwt.ClaimStrings{Audience},
+            IssuedAt:  jwt.NewNumericDate(time.Now()),
+            ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+            Subject:   principal.UserID.String(),
+        },
+        TokenVersion: principal.TokenVersion,
+        Scope:        FormatScopes(principal.Scopes),
+        Roles:        principal.Roles,
+    }
+    if principal.SessionID != uuid.Nil {
+        claims.SessionID = principal.SessionID.String()
     }
 
-    token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-    signed, err := token.SignedString(secretKey)
+    signed, err := keys.sign(claims)
     if err != nil {
         return "", err
     }
@@ -29,26 +38,65 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 
 type MyCustomClaims struct {
 	jwt.RegisteredClaims
+	TokenVersion int32 `json:"ver"`
+	Scope string `json:"scope"`
+	Roles []string `json:"roles"`
+	SessionID string `json:"sid,omitempty"`
 }	
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+// TokenVersionFunc looks up the current token version of a user.
+type TokenVersionFunc func(userID uuid.UUID) (int32, error)
+
+// ValidateJWT checks the expiry, issuer and audience of an access token and
+// its signature against the keyring key named by its kid header, and returns
+// the principal it was issued to. When currentVersion is not nil, tokens
+// minted before the user's last version bump are rejected too.
+func ValidateJWT(tokenString string, keys *Keyring, currentVersion TokenVersionFunc) (Principal, error) {
 	claims := &MyCustomClaims{}
-	t, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (any, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-            return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-        }
-        return []byte(tokenSecret), nil
-		})
+	t, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc,
+		jwt.WithIssuer(Issuer),
+		jwt.WithAudience(Audience),
+		jwt.WithExpirationRequired(),
+	)
 	
 	if err != nil {
-		return uuid.UUID{}, err
+		return Principal{}, err
 	}
 
 	if !t.Valid {
-		return uuid.UUID{}, fmt.Errorf("token is invalid")
+		return Principal{}, fmt.Errorf("token is invalid")
 	}
 
 	user := claims.Subject
 
-	return uuid.Parse(user)
-}
\ No newline at end of file
+	userID, err := uuid.Parse(user)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if currentVersion != nil {
+		version, err := currentVersion(userID)
+		if err != nil {
+			return Principal{}, err
+		}
+		if claims.TokenVersion != version {
+			return Principal{}, fmt.Errorf("token has been revoked")
+		}
+	}
+
+	sessionID := uuid.Nil
+	if claims.SessionID != "" {
+		sessionID, err = uuid.Parse(claims.SessionID)
+		if err != nil {
+			return Principal{}, err
+		}
+	}
+
+	return Principal{
+		UserID: userID,
+		Scopes: strings.Fields(claims.Scope),
+		Roles: claims.Roles,
+		TokenVersion: claims.TokenVersion,
+		SessionID: sessionID,
+	}, nil
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key is one entry of a Keyring. Private is nil for keys that are only kept
+// to verify tokens signed before a rotation.
+type Key struct {
+	ID      string
+	Public  crypto.PublicKey
+	Private crypto.Signer
+	Method  jwt.SigningMethod
+}
+
+// Keyring signs access tokens with one key and verifies them against every
+// key it holds, so a new signing key can be rolled out while tokens signed
+// by the previous one are still in circulation.
+type Keyring struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeyring builds a keyring that signs with the key whose ID is signingID.
+func NewKeyring(signingID string, keys ...*Key) (*Keyring, error) {
+	kr := &Keyring{keys: map[string]*Key{}}
+	for _, k := range keys {
+		if _, ok := kr.keys[k.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", k.ID)
+		}
+		kr.keys[k.ID] = k
+	}
+
+	signing, ok := kr.keys[signingID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q not found", signingID)
+	}
+	if signing.Private == nil {
+		return nil, fmt.Errorf("signing key %q has no private key", signingID)
+	}
+	kr.signing = signing
+	return kr, nil
+}
+
+// NewKey wraps an RSA or Ed25519 key, private or public.
+func NewKey(id string, key any) (*Key, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &Key{ID: id, Public: &k.PublicKey, Private: k, Method: jwt.SigningMethodRS256}, nil
+	case *rsa.PublicKey:
+		return &Key{ID: id, Public: k, Method: jwt.SigningMethodRS256}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Public: k.Public(), Private: k, Method: jwt.SigningMethodEdDSA}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, Public: k, Method: jwt.SigningMethodEdDSA}, nil
+	}
+	return nil, fmt.Errorf("key %q: unsupported key type %T", id, key)
+}
+
+// GenerateKeyring returns a keyring with a single fresh Ed25519 key. Tokens it
+// signs stop validating when the process exits, so it only suits development.
+func GenerateKeyring() (*Keyring, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key, err := NewKey("ephemeral", private)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(key.ID, key)
+}
+
+// LoadKeyring reads every *.pem file in dir. Each file holds a PKCS#8
+// private key or a PKIX public key and its name without the extension is the
+// kid. Public-only keys are retired keys that still verify.
+func LoadKeyring(dir, signingID string) (*Keyring, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	keys := []*Key{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		id := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := parsePEMKey(id, data)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return NewKeyring(signingID, keys...)
+}
+
+func parsePEMKey(id string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %q: no PEM block found", id)
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("key %q: unsupported PEM block %q", id, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", id, err)
+	}
+	return NewKey(id, parsed)
+}
+
+func (kr *Keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(kr.signing.Method, claims)
+	token.Header["kid"] = kr.signing.ID
+	return token.SignedString(kr.signing.Private)
+}
+
+// keyFunc resolves the verification key from the kid header and refuses
+// tokens whose alg doesn't match that key.
+func (kr *Keyring) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := kr.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// JWK is a public key in JSON Web Key form.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every key, ordered by kid.
+func (kr *Keyring) JWKS() JWKS {
+	ids := make([]string, 0, len(kr.keys))
+	for id := range kr.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	set := JWKS{Keys: []JWK{}}
+	for _, id := range ids {
+		key := kr.keys[id]
+		jwk := JWK{Kid: id, Use: "sig", Alg: key.Method.Alg()}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKeyring(t *testing.T) *Keyring {
+	t.Helper()
+	kr, err := GenerateKeyring()
+	require.NoError(t, err)
+	return kr
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func TestKeyringRotation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	oldKey, err := NewKey("2025-01", rsaKey)
+	require.NoError(t, err)
+	newKey, err := NewKey("2025-06", edKey)
+	require.NoError(t, err)
+
+	before, err := NewKeyring("2025-01", oldKey)
+	require.NoError(t, err)
+	userID := uuid.New()
+	oldToken, err := MakeJWT(Principal{UserID: userID}, before, time.Minute)
+	require.NoError(t, err)
+
+	retired, err := NewKey("2025-01", &rsaKey.PublicKey)
+	require.NoError(t, err)
+	after, err := NewKeyring("2025-06", retired, newKey)
+	require.NoError(t, err)
+
+	got, err := ValidateJWT(oldToken, after, nil)
+	require.NoError(t, err, "tokens signed before a rotation should still validate")
+	require.Equal(t, userID, got.UserID)
+
+	newToken, err := MakeJWT(Principal{UserID: userID}, after, time.Minute)
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &MyCustomClaims{})
+	require.NoError(t, err)
+	require.Equal(t, "2025-06", parsed.Header["kid"])
+	require.Equal(t, "EdDSA", parsed.Header["alg"])
+
+	_, err = ValidateJWT(newToken, before, nil)
+	require.Error(t, err, "unknown kid should be rejected")
+}
+
+func TestNewKeyringErrors(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	public, err := NewKey("pub", edKey.Public())
+	require.NoError(t, err)
+
+	_, err = NewKeyring("missing", public)
+	require.Error(t, err)
+
+	_, err = NewKeyring("pub", public)
+	require.ErrorContains(t, err, "no private key")
+}
+
+func TestValidateJWT_AlgMismatch(t *testing.T) {
+	kr := newTestKeyring(t)
+
+	// an HS256 token that names a real kid must not be accepted
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   uuid.NewString(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	})
+	token.Header["kid"] = "ephemeral"
+	signed, err := token.SignedString([]byte("guess"))
+	require.NoError(t, err)
+
+	_, err = ValidateJWT(signed, kr, nil)
+	require.Error(t, err)
+}
+
+func TestLoadKeyringAndJWKS(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "old.pem"), "PUBLIC KEY", der)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err = x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	writePEM(t, filepath.Join(dir, "current.pem"), "PRIVATE KEY", der)
+
+	kr, err := LoadKeyring(dir, "current")
+	require.NoError(t, err)
+
+	set := kr.JWKS()
+	require.Len(t, set.Keys, 2)
+
+	current, old := set.Keys[0], set.Keys[1]
+	require.Equal(t, "current", current.Kid)
+	require.Equal(t, "OKP", current.Kty)
+	require.Equal(t, "Ed25519", current.Crv)
+	require.Equal(t, "EdDSA", current.Alg)
+	require.NotEmpty(t, current.X)
+
+	require.Equal(t, "old", old.Kid)
+	require.Equal(t, "RSA", old.Kty)
+	require.Equal(t, "RS256", old.Alg)
+	require.Equal(t, "AQAB", old.E)
+	require.NotEmpty(t, old.N)
+
+	_, err = LoadKeyring(dir, "old")
+	require.Error(t, err, "a public-only key can't sign")
+}
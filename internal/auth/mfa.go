@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// MFAAudience marks challenge tokens. They are signed like access tokens but
+// ValidateJWT rejects them, so they are only good for finishing a login.
+const MFAAudience = "chirpy-mfa"
+
+// MakeMFAChallenge signs a short-lived token saying the password of userID
+// was correct. The scopes the login asked for ride along.
+func MakeMFAChallenge(userID uuid.UUID, scopes []string, keys *Keyring, expiresIn time.Duration) (string, error) {
+	claims := MyCustomClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    Issuer,
+			Audience:  jwt.ClaimStrings{MFAAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+		Scope: FormatScopes(scopes),
+	}
+	return keys.sign(claims)
+}
+
+// ValidateMFAChallenge checks a token from MakeMFAChallenge and returns the
+// user and scopes it was issued for.
+func ValidateMFAChallenge(tokenString string, keys *Keyring) (Principal, error) {
+	claims := &MyCustomClaims{}
+	t, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc,
+		jwt.WithIssuer(Issuer),
+		jwt.WithAudience(MFAAudience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return Principal{}, err
+	}
+	if !t.Valid {
+		return Principal{}, fmt.Errorf("token is invalid")
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{UserID: userID, Scopes: strings.Fields(claims.Scope)}, nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MakeRecoveryCodes returns n random one-time codes like "abcd-efgh-ijkl-mnop".
+func MakeRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		key := make([]byte, 10)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to read random bytes: %w", err)
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(key))
+		codes[i] = raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+	}
+	return codes, nil
+}
+
+// HashRecoveryCode is what gets stored for a recovery code. The codes carry
+// 80 random bits, so a plain SHA-256 is enough; dashes, spaces and case are
+// ignored so users can type them back however they like.
+func HashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
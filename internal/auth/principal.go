@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// Issuer and Audience are set on every access token and required when
+	// validating one.
+	Issuer   = "chirpy"
+	Audience = "chirpy-api"
+)
+
+const (
+	ScopeChirpsRead   = "chirps:read"
+	ScopeChirpsWrite  = "chirps:write"
+	ScopeSocialWrite  = "social:write"
+	ScopeAccountRead  = "account:read"
+	ScopeAccountWrite = "account:write"
+)
+
+// AllScopes is what a token gets when the client doesn't ask for less.
+var AllScopes = []string{
+	ScopeChirpsRead,
+	ScopeChirpsWrite,
+	ScopeSocialWrite,
+	ScopeAccountRead,
+	ScopeAccountWrite,
+}
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Permission is something a role allows beyond what every user can do.
+type Permission string
+
+const (
+	PermViewMetrics    Permission = "metrics:view"
+	PermResetData      Permission = "data:reset"
+	PermModerate       Permission = "moderation:manage"
+	PermDeleteAnyChirp Permission = "chirps:delete_any"
+	PermManageRoles    Permission = "users:manage_roles"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleUser:      {},
+	RoleModerator: {PermViewMetrics, PermModerate, PermDeleteAnyChirp},
+	RoleAdmin:     {PermViewMetrics, PermResetData, PermModerate, PermDeleteAnyChirp, PermManageRoles},
+}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Principal is the caller an access token was issued to.
+type Principal struct {
+	UserID       uuid.UUID
+	Scopes       []string
+	Roles        []string
+	TokenVersion int32
+	// SessionID is the refresh token family the token was issued from, or
+	// uuid.Nil for tokens that predate sessions.
+	SessionID uuid.UUID
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+func (p Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// Can reports whether any of the principal's roles grants perm.
+func (p Principal) Can(perm Permission) bool {
+	for _, role := range p.Roles {
+		if slices.Contains(rolePermissions[role], perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScopes splits a space separated scope string, as used in the scope
+// claim and the login request. An empty string means every scope.
+func ParseScopes(s string) ([]string, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return slices.Clone(AllScopes), nil
+	}
+
+	scopes := []string{}
+	for _, scope := range fields {
+		if !slices.Contains(AllScopes, scope) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+func FormatScopes(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
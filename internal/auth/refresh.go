@@ -1,20 +1,48 @@
 package auth
 
 import (
-    "crypto/rand"
-    "encoding/hex"
-    "fmt"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 )
 
+// RefreshTokenPrefixLen is how many leading characters of a refresh token are
+// stored in the clear so the row can be found by an index.
+const RefreshTokenPrefixLen = 8
+
 func MakeRefreshToken() (string, error) {
-    key := make([]byte, 32)
-    n, err := rand.Read(key)
-    if err != nil {
-        return "", fmt.Errorf("failed to read random bytes: %w", err)
-    }
-    if n != len(key) {
-        return "", fmt.Errorf("read %d random bytes; expected %d", n, len(key))
-    }
-    encoded := hex.EncodeToString(key)
-    return encoded, nil
-}
\ No newline at end of file
+	key := make([]byte, 32)
+	n, err := rand.Read(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	if n != len(key) {
+		return "", fmt.Errorf("read %d random bytes; expected %d", n, len(key))
+	}
+	encoded := hex.EncodeToString(key)
+	return encoded, nil
+}
+
+// HashRefreshToken returns the lookup prefix and the HMAC-SHA256 of token
+// under key. Only these are stored, so a leaked table can't be replayed
+// without also knowing the key.
+func HashRefreshToken(token string, key []byte) (prefix string, hash string) {
+	hash = HashToken(token, key)
+
+	prefix = token
+	if len(prefix) > RefreshTokenPrefixLen {
+		prefix = prefix[:RefreshTokenPrefixLen]
+	}
+	return prefix, hash
+}
+
+// HashToken returns the hex HMAC-SHA256 of an opaque token under key. It is
+// used for bearer secrets that are looked up by their hash, such as password
+// reset tokens.
+func HashToken(token string, key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,42 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	s := FileStore{Dir: t.TempDir()}
+
+	require.NoError(t, s.Put(ctx, "avatars/abc/1.png", strings.NewReader("first")))
+	require.NoError(t, s.Put(ctx, "avatars/abc/1.png", strings.NewReader("second")))
+
+	rc, err := s.Open(ctx, "avatars/abc/1.png")
+	require.NoError(t, err)
+	data, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.Equal(t, "second", string(data))
+
+	require.NoError(t, s.Delete(ctx, "avatars/abc/1.png"))
+	require.NoError(t, s.Delete(ctx, "avatars/abc/1.png"), "deleting twice is fine")
+
+	_, err = s.Open(ctx, "avatars/abc/1.png")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestFileStoreKeys(t *testing.T) {
+	ctx := context.Background()
+	s := FileStore{Dir: t.TempDir()}
+
+	for _, key := range []string{"", "../escape", "/etc/passwd", "a/../../b", "a/../b", "a//b", "a/./b"} {
+		require.Error(t, s.Put(ctx, key, strings.NewReader("x")), "key %q", key)
+		_, err := s.Open(ctx, key)
+		require.ErrorIs(t, err, ErrNotFound, "key %q", key)
+	}
+}
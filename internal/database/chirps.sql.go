@@ -7,29 +7,83 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES (
-    gen_random_uuid(),
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, conversation_id, quote_of)
+SELECT
+    generated.id,
     NOW(),
     NOW(),
     $1,
-    $2
-)
-RETURNING id, user_id, created_at, updated_at, body
+    $2::uuid,
+    $3,
+    COALESCE(parent.conversation_id, generated.id),
+    $4
+FROM (SELECT gen_random_uuid() AS id) AS generated
+LEFT JOIN chirps parent ON parent.id = $3
+RETURNING id, user_id, created_at, updated_at, body, in_reply_to, conversation_id, deleted_at, search_vector, like_count, rechirp_of, quote_of
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body      string
+	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
+	QuoteOf   uuid.NullUUID
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.InReplyTo,
+		arg.QuoteOf,
+	)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.DeletedAt,
+		&i.SearchVector,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+	)
+	return i, err
+}
+
+const createRechirp = `-- name: CreateRechirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, conversation_id, rechirp_of)
+SELECT
+    generated.id,
+    NOW(),
+    NOW(),
+    '',
+    $1::uuid,
+    generated.id,
+    $2::uuid
+FROM (SELECT gen_random_uuid() AS id) AS generated
+ON CONFLICT (user_id, rechirp_of) WHERE rechirp_of IS NOT NULL DO NOTHING
+RETURNING id, user_id, created_at, updated_at, body, in_reply_to, conversation_id, deleted_at, search_vector, like_count, rechirp_of, quote_of
+`
+
+type CreateRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.UUID
+}
+
+func (q *Queries) CreateRechirp(ctx context.Context, arg CreateRechirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, createRechirp, arg.UserID, arg.RechirpOf)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -37,13 +91,24 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.Body,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.DeletedAt,
+		&i.SearchVector,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
 	)
 	return i, err
 }
 
-const deleteChirpByID = `-- name: DeleteChirpByID :exec
+const deleteChirpByID = `-- name: DeleteChirpByID :execrows
 DELETE FROM chirps
-WHERE id=$1 AND user_id=$2
+WHERE id=$1 AND user_id=$2::uuid
+AND NOT EXISTS (
+    SELECT 1 FROM chirps ref
+    WHERE ref.in_reply_to=$1 OR ref.quote_of=$1 OR ref.rechirp_of=$1
+)
 `
 
 type DeleteChirpByIDParams struct {
@@ -51,13 +116,96 @@ type DeleteChirpByIDParams struct {
 	UserID uuid.UUID
 }
 
-func (q *Queries) DeleteChirpByID(ctx context.Context, arg DeleteChirpByIDParams) error {
-	_, err := q.db.ExecContext(ctx, deleteChirpByID, arg.ID, arg.UserID)
+func (q *Queries) DeleteChirpByID(ctx context.Context, arg DeleteChirpByIDParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteChirpByID, arg.ID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteChirpsByIDs = `-- name: DeleteChirpsByIDs :exec
+DELETE FROM chirps
+WHERE id = ANY($1::uuid[])
+`
+
+func (q *Queries) DeleteChirpsByIDs(ctx context.Context, ids []uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, deleteChirpsByIDs, pq.Array(ids))
 	return err
 }
 
+const deleteRechirp = `-- name: DeleteRechirp :execrows
+DELETE FROM chirps
+WHERE user_id = $1::uuid AND rechirp_of = $2::uuid
+`
+
+type DeleteRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.UUID
+}
+
+func (q *Queries) DeleteRechirp(ctx context.Context, arg DeleteRechirpParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteRechirp, arg.UserID, arg.RechirpOf)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getChirpAncestors = `-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT parent.id, parent.in_reply_to, 1 AS depth
+    FROM chirps parent
+    WHERE parent.id = (SELECT child.in_reply_to FROM chirps child WHERE child.id = $1)
+    UNION ALL
+    SELECT parent.id, parent.in_reply_to, ancestors.depth + 1
+    FROM chirps parent
+    JOIN ancestors ON parent.id = ancestors.in_reply_to
+)
+SELECT chirps.id, chirps.user_id, chirps.created_at, chirps.updated_at, chirps.body, chirps.in_reply_to, chirps.conversation_id, chirps.deleted_at, chirps.search_vector, chirps.like_count, chirps.rechirp_of, chirps.quote_of FROM chirps
+JOIN ancestors ON ancestors.id = chirps.id
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+ORDER BY ancestors.depth DESC
+`
+
+func (q *Queries) GetChirpAncestors(ctx context.Context, chirpID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpAncestors, chirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getChirpByID = `-- name: GetChirpByID :one
-SELECT id, user_id, created_at, updated_at, body FROM chirps
+SELECT id, user_id, created_at, updated_at, body, in_reply_to, conversation_id, deleted_at, search_vector, like_count, rechirp_of, quote_of FROM chirps
 WHERE id=$1
 `
 
@@ -70,17 +218,111 @@ func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.Body,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.DeletedAt,
+		&i.SearchVector,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
 	)
 	return i, err
 }
 
-const getChirps = `-- name: GetChirps :many
-SELECT id, user_id, created_at, updated_at, body FROM chirps 
-ORDER BY created_at ASC
+const getChirpDescendants = `-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT child.id, 1 AS depth
+    FROM chirps child
+    WHERE child.in_reply_to = $1
+    UNION ALL
+    SELECT child.id, descendants.depth + 1
+    FROM chirps child
+    JOIN descendants ON child.in_reply_to = descendants.id
+)
+SELECT chirps.id, chirps.user_id, chirps.created_at, chirps.updated_at, chirps.body, chirps.in_reply_to, chirps.conversation_id, chirps.deleted_at, chirps.search_vector, chirps.like_count, chirps.rechirp_of, chirps.quote_of, descendants.depth FROM chirps
+JOIN descendants ON descendants.id = chirps.id
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+AND (
+    $2::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) > ($2, $3::uuid)
+)
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT $4
+`
+
+type GetChirpDescendantsParams struct {
+	ChirpID         uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+type GetChirpDescendantsRow struct {
+	ID             uuid.UUID
+	UserID         uuid.NullUUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Body           string
+	InReplyTo      uuid.NullUUID
+	ConversationID uuid.UUID
+	DeletedAt      sql.NullTime
+	SearchVector   interface{}
+	LikeCount      int32
+	RechirpOf      uuid.NullUUID
+	QuoteOf        uuid.NullUUID
+	Depth          int32
+}
+
+func (q *Queries) GetChirpDescendants(ctx context.Context, arg GetChirpDescendantsParams) ([]GetChirpDescendantsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpDescendants,
+		arg.ChirpID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetChirpDescendantsRow
+	for rows.Next() {
+		var i GetChirpDescendantsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.Depth,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsByIDs = `-- name: GetChirpsByIDs :many
+SELECT id, user_id, created_at, updated_at, body, in_reply_to, conversation_id, deleted_at, search_vector, like_count, rechirp_of, quote_of FROM chirps
+WHERE id = ANY($1::uuid[])
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
 `
 
-func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirps)
+func (q *Queries) GetChirpsByIDs(ctx context.Context, ids []uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByIDs, pq.Array(ids))
 	if err != nil {
 		return nil, err
 	}
@@ -94,6 +336,13 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Body,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
 		); err != nil {
 			return nil, err
 		}
@@ -108,14 +357,64 @@ func (q *Queries) GetChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getChirpsByAuthor = `-- name: GetChirpsByAuthor :many
-SELECT id, user_id, created_at, updated_at, body FROM chirps
-WHERE user_id=$1
-ORDER BY created_at ASC
+const getRechirp = `-- name: GetRechirp :one
+SELECT id, user_id, created_at, updated_at, body, in_reply_to, conversation_id, deleted_at, search_vector, like_count, rechirp_of, quote_of FROM chirps
+WHERE user_id = $1::uuid AND rechirp_of = $2::uuid
+`
+
+type GetRechirpParams struct {
+	UserID    uuid.UUID
+	RechirpOf uuid.UUID
+}
+
+func (q *Queries) GetRechirp(ctx context.Context, arg GetRechirpParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getRechirp, arg.UserID, arg.RechirpOf)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.DeletedAt,
+		&i.SearchVector,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+	)
+	return i, err
+}
+
+const getTimeline = `-- name: GetTimeline :many
+SELECT chirps.id, chirps.user_id, chirps.created_at, chirps.updated_at, chirps.body, chirps.in_reply_to, chirps.conversation_id, chirps.deleted_at, chirps.search_vector, chirps.like_count, chirps.rechirp_of, chirps.quote_of FROM chirps
+JOIN follows ON follows.followee_id = chirps.user_id
+WHERE follows.follower_id = $1
+AND chirps.deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+AND (
+    $2::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < ($2, $3::uuid)
+)
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT $4
 `
 
-func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getChirpsByAuthor, userID)
+type GetTimelineParams struct {
+	UserID          uuid.UUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) GetTimeline(ctx context.Context, arg GetTimelineParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getTimeline,
+		arg.UserID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -129,6 +428,13 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 			&i.CreatedAt,
 			&i.UpdatedAt,
 			&i.Body,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
 		); err != nil {
 			return nil, err
 		}
@@ -142,3 +448,375 @@ func (q *Queries) GetChirpsByAuthor(ctx context.Context, userID uuid.UUID) ([]Ch
 	}
 	return items, nil
 }
+
+const getVisibleChirpByID = `-- name: GetVisibleChirpByID :one
+SELECT id, user_id, created_at, updated_at, body, in_reply_to, conversation_id, deleted_at, search_vector, like_count, rechirp_of, quote_of FROM chirps
+WHERE id=$1
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+`
+
+func (q *Queries) GetVisibleChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getVisibleChirpByID, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.InReplyTo,
+		&i.ConversationID,
+		&i.DeletedAt,
+		&i.SearchVector,
+		&i.LikeCount,
+		&i.RechirpOf,
+		&i.QuoteOf,
+	)
+	return i, err
+}
+
+const listChirpsAsc = `-- name: ListChirpsAsc :many
+SELECT id, user_id, created_at, updated_at, body, in_reply_to, conversation_id, deleted_at, search_vector, like_count, rechirp_of, quote_of FROM chirps
+WHERE deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+AND ($1::uuid IS NULL OR user_id = $1)
+AND (
+    $2::timestamp IS NULL
+    OR (created_at, id) > ($2, $3::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT $4
+`
+
+type ListChirpsAscParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAsc,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT id, user_id, created_at, updated_at, body, in_reply_to, conversation_id, deleted_at, search_vector, like_count, rechirp_of, quote_of FROM chirps
+WHERE deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+AND ($1::uuid IS NULL OR user_id = $1)
+AND (
+    $2::timestamp IS NULL
+    OR (created_at, id) < ($2, $3::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type ListChirpsDescParams struct {
+	AuthorID        uuid.NullUUID
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		arg.AuthorID,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsForPurge = `-- name: ListChirpsForPurge :many
+SELECT id, user_id, created_at, updated_at, body, in_reply_to, conversation_id, deleted_at, search_vector, like_count, rechirp_of, quote_of FROM chirps
+WHERE user_id = $1::uuid
+`
+
+func (q *Queries) ListChirpsForPurge(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsForPurge, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsReferencedByOthers = `-- name: ListChirpsReferencedByOthers :many
+SELECT DISTINCT chirps.id FROM chirps
+JOIN chirps ref ON ref.in_reply_to = chirps.id OR ref.quote_of = chirps.id OR ref.rechirp_of = chirps.id
+WHERE chirps.user_id = $1::uuid
+AND ref.user_id IS DISTINCT FROM chirps.user_id
+`
+
+func (q *Queries) ListChirpsReferencedByOthers(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsReferencedByOthers, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUserChirps = `-- name: ListUserChirps :many
+SELECT id, user_id, created_at, updated_at, body, in_reply_to, conversation_id, deleted_at, search_vector, like_count, rechirp_of, quote_of FROM chirps
+WHERE user_id = $1::uuid AND deleted_at IS NULL
+ORDER BY created_at, id
+`
+
+func (q *Queries) ListUserChirps(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listUserChirps, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT chirps.id, chirps.user_id, chirps.created_at, chirps.updated_at, chirps.body, chirps.in_reply_to, chirps.conversation_id, chirps.deleted_at, chirps.search_vector, chirps.like_count, chirps.rechirp_of, chirps.quote_of,
+    ts_rank_cd(chirps.search_vector, query)::real AS rank,
+    -- the body is HTML-escaped first, so the only markup in a snippet is <mark>
+    ts_headline(
+        'english',
+        replace(replace(replace(replace(replace(chirps.body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;'),
+        query,
+        'StartSel=<mark>, StopSel=</mark>, MaxFragments=2'
+    ) AS snippet
+FROM chirps, to_tsquery('english', $1) query
+WHERE chirps.deleted_at IS NULL
+AND chirps.search_vector @@ query
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+AND (
+    $2::real IS NULL
+    OR (ts_rank_cd(chirps.search_vector, query), chirps.created_at, chirps.id)
+        < ($2, $3::timestamp, $4::uuid)
+)
+ORDER BY rank DESC, chirps.created_at DESC, chirps.id DESC
+LIMIT $5
+`
+
+type SearchChirpsParams struct {
+	Query           string
+	CursorRank      sql.NullFloat64
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageLimit       int32
+}
+
+type SearchChirpsRow struct {
+	ID             uuid.UUID
+	UserID         uuid.NullUUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Body           string
+	InReplyTo      uuid.NullUUID
+	ConversationID uuid.UUID
+	DeletedAt      sql.NullTime
+	SearchVector   interface{}
+	LikeCount      int32
+	RechirpOf      uuid.NullUUID
+	QuoteOf        uuid.NullUUID
+	Rank           float32
+	Snippet        string
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.CursorRank,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.InReplyTo,
+			&i.ConversationID,
+			&i.DeletedAt,
+			&i.SearchVector,
+			&i.LikeCount,
+			&i.RechirpOf,
+			&i.QuoteOf,
+			&i.Rank,
+			&i.Snippet,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :exec
+UPDATE chirps
+SET
+body='',
+deleted_at=NOW(),
+updated_at=NOW()
+WHERE id=$1 AND user_id=$2::uuid
+`
+
+type TombstoneChirpParams struct {
+	ID     uuid.UUID
+	UserID uuid.UUID
+}
+
+func (q *Queries) TombstoneChirp(ctx context.Context, arg TombstoneChirpParams) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirp, arg.ID, arg.UserID)
+	return err
+}
@@ -11,28 +11,194 @@ import (
 	"github.com/google/uuid"
 )
 
+type AccountUnlockToken struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	TokenHash string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
 type Chirp struct {
+	ID             uuid.UUID
+	UserID         uuid.NullUUID
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Body           string
+	InReplyTo      uuid.NullUUID
+	ConversationID uuid.UUID
+	DeletedAt      sql.NullTime
+	SearchVector   interface{}
+	LikeCount      int32
+	RechirpOf      uuid.NullUUID
+	QuoteOf        uuid.NullUUID
+}
+
+type ChirpHashtag struct {
+	ChirpID   uuid.UUID
+	Tag       string
+	CreatedAt time.Time
+}
+
+type ChirpMention struct {
+	ChirpID   uuid.UUID
+	Handle    string
+	CreatedAt time.Time
+}
+
+type ChirpRevision struct {
+	ID         uuid.UUID
+	ChirpID    uuid.UUID
+	Body       string
+	CreatedAt  time.Time
+	ReplacedAt time.Time
+}
+
+type ChirpUrl struct {
+	ChirpID  uuid.UUID
+	Position int32
+	Url      string
+}
+
+type DataExport struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Status      string
+	BlobKey     sql.NullString
+	CreatedAt   time.Time
+	CompletedAt sql.NullTime
+	ExpiresAt   sql.NullTime
+}
+
+type EmailVerificationToken struct {
 	ID        uuid.UUID
 	UserID    uuid.UUID
+	TokenHash string
 	CreatedAt time.Time
-	UpdatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	CreatedAt  time.Time
+}
+
+type Hashtag struct {
+	Tag       string
+	CreatedAt time.Time
+}
+
+type HeldChirp struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
 	Body      string
+	InReplyTo uuid.NullUUID
+	QuoteOf   uuid.NullUUID
+	Matched   string
+	CreatedAt time.Time
 }
 
-type RefreshToken struct {
-	Token     string
+type Like struct {
+	ChirpID   uuid.UUID
+	UserID    uuid.UUID
+	CreatedAt time.Time
+}
+
+type LoginIpFailure struct {
+	IpAddress    string
+	Failures     int32
+	LastFailedAt time.Time
+	BlockedUntil sql.NullTime
+}
+
+type MfaRecoveryCode struct {
+	ID        uuid.UUID
+	UserID    uuid.UUID
+	CodeHash  string
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type ModerationRule struct {
+	ID        uuid.UUID
+	Pattern   string
+	Action    string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+}
+
+type PasswordResetToken struct {
+	ID        uuid.UUID
 	UserID    uuid.UUID
+	TokenHash string
+	CreatedAt time.Time
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
+}
+
+type Reaction struct {
+	ChirpID   uuid.UUID
+	UserID    uuid.UUID
+	Emoji     string
+	CreatedAt time.Time
+}
+
+type ReactionCount struct {
+	ChirpID uuid.UUID
+	Emoji   string
+	Count   int32
+}
+
+type RefreshToken struct {
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	UserID       uuid.UUID
+	ExpiresAt    time.Time
+	RevokedAt    sql.NullTime
+	FamilyID     uuid.UUID
+	ID           uuid.UUID
+	LookupPrefix string
+	TokenHash    string
+	ReplacedBy   uuid.NullUUID
+	UserAgent    string
+	IpAddress    string
+	LastUsedAt   time.Time
+	Scope        string
+}
+
+type SecurityEvent struct {
+	ID        uuid.UUID
+	UserID    uuid.NullUUID
+	EventType string
+	IpAddress string
+	UserAgent string
+	Detail    string
+	CreatedAt time.Time
 }
 
 type User struct {
-	ID             uuid.UUID
-	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    sql.NullBool
+	ID                uuid.UUID
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Email             string
+	HashedPassword    string
+	IsChirpyRed       sql.NullBool
+	TokenVersion      int32
+	Role              string
+	TotpSecret        sql.NullString
+	TotpEnabledAt     sql.NullTime
+	TotpLastStep      sql.NullInt64
+	EmailVerifiedAt   sql.NullTime
+	FailedLoginCount  int32
+	LastFailedLoginAt sql.NullTime
+	LockedUntil       sql.NullTime
+	Handle            string
+	DisplayName       string
+	Bio               string
+	AvatarKey         sql.NullString
+	DeletedAt         sql.NullTime
+	PurgeAfter        sql.NullTime
 }
@@ -14,59 +14,292 @@ import (
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
+INSERT INTO refresh_tokens (id, lookup_prefix, token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, last_used_at, scope)
 VALUES (
     $1,
-    NOW(),
-    NOW(),
     $2,
     $3,
-    $4
+    NOW(),
+    NOW(),
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    NOW(),
+    $10
 )
-RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+RETURNING created_at, updated_at, user_id, expires_at, revoked_at, family_id, id, lookup_prefix, token_hash, replaced_by, user_agent, ip_address, last_used_at, scope
 `
 
 type CreateRefreshTokenParams struct {
-	Token     string
-	UserID    uuid.UUID
-	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	ID           uuid.UUID
+	LookupPrefix string
+	TokenHash    string
+	UserID       uuid.UUID
+	ExpiresAt    time.Time
+	RevokedAt    sql.NullTime
+	FamilyID     uuid.UUID
+	UserAgent    string
+	IpAddress    string
+	Scope        string
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
 	row := q.db.QueryRowContext(ctx, createRefreshToken,
-		arg.Token,
+		arg.ID,
+		arg.LookupPrefix,
+		arg.TokenHash,
 		arg.UserID,
 		arg.ExpiresAt,
 		arg.RevokedAt,
+		arg.FamilyID,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.Scope,
 	)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ID,
+		&i.LookupPrefix,
+		&i.TokenHash,
+		&i.ReplacedBy,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+		&i.Scope,
+	)
+	return i, err
+}
+
+const getAnyRefreshToken = `-- name: GetAnyRefreshToken :one
+SELECT created_at, updated_at, user_id, expires_at, revoked_at, family_id, id, lookup_prefix, token_hash, replaced_by, user_agent, ip_address, last_used_at, scope FROM refresh_tokens
+WHERE lookup_prefix = $1 AND token_hash = $2
+`
+
+type GetAnyRefreshTokenParams struct {
+	LookupPrefix string
+	TokenHash    string
+}
+
+func (q *Queries) GetAnyRefreshToken(ctx context.Context, arg GetAnyRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getAnyRefreshToken, arg.LookupPrefix, arg.TokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ID,
+		&i.LookupPrefix,
+		&i.TokenHash,
+		&i.ReplacedBy,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+		&i.Scope,
 	)
 	return i, err
 }
 
 const getRefreshTokenByToken = `-- name: GetRefreshTokenByToken :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens
-WHERE token = $1 AND expires_at > NOW() AND revoked_at is NULL
+SELECT created_at, updated_at, user_id, expires_at, revoked_at, family_id, id, lookup_prefix, token_hash, replaced_by, user_agent, ip_address, last_used_at, scope FROM refresh_tokens
+WHERE lookup_prefix = $1 AND token_hash = $2 AND expires_at > NOW() AND revoked_at is NULL
+`
+
+type GetRefreshTokenByTokenParams struct {
+	LookupPrefix string
+	TokenHash    string
+}
+
+func (q *Queries) GetRefreshTokenByToken(ctx context.Context, arg GetRefreshTokenByTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshTokenByToken, arg.LookupPrefix, arg.TokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ID,
+		&i.LookupPrefix,
+		&i.TokenHash,
+		&i.ReplacedBy,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+		&i.Scope,
+	)
+	return i, err
+}
+
+const listSessions = `-- name: ListSessions :many
+SELECT
+    t.family_id,
+    t.user_agent,
+    t.ip_address,
+    t.last_used_at,
+    (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = t.family_id)::timestamp AS signed_in_at
+FROM refresh_tokens t
+WHERE t.user_id = $1 AND t.revoked_at IS NULL AND t.expires_at > NOW()
+ORDER BY t.last_used_at DESC
+`
+
+type ListSessionsRow struct {
+	FamilyID   uuid.UUID
+	UserAgent  string
+	IpAddress  string
+	LastUsedAt time.Time
+	SignedInAt time.Time
+}
+
+func (q *Queries) ListSessions(ctx context.Context, userID uuid.UUID) ([]ListSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListSessionsRow
+	for rows.Next() {
+		var i ListSessionsRow
+		if err := rows.Scan(
+			&i.FamilyID,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.LastUsedAt,
+			&i.SignedInAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeOtherSessions = `-- name: RevokeOtherSessions :execrows
+UPDATE refresh_tokens
+SET
+revoked_at = NOW(),
+updated_at = NOW()
+WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL
 `
 
-func (q *Queries) GetRefreshTokenByToken(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getRefreshTokenByToken, token)
+type RevokeOtherSessionsParams struct {
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeOtherSessions(ctx context.Context, arg RevokeOtherSessionsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeOtherSessions, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :execrows
+UPDATE refresh_tokens
+SET
+revoked_at = NOW(),
+updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeSession = `-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET
+revoked_at = NOW(),
+updated_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL
+`
+
+type RevokeSessionParams struct {
+	FamilyID uuid.UUID
+	UserID   uuid.UUID
+}
+
+func (q *Queries) RevokeSession(ctx context.Context, arg RevokeSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeSession, arg.FamilyID, arg.UserID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeUserRefreshTokens = `-- name: RevokeUserRefreshTokens :execrows
+UPDATE refresh_tokens
+SET
+revoked_at = NOW(),
+updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeUserRefreshTokens, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :one
+UPDATE refresh_tokens
+SET
+revoked_at = NOW(),
+updated_at = NOW(),
+replaced_by = $1::uuid
+WHERE lookup_prefix = $2 AND token_hash = $3 AND expires_at > NOW() AND revoked_at IS NULL
+RETURNING created_at, updated_at, user_id, expires_at, revoked_at, family_id, id, lookup_prefix, token_hash, replaced_by, user_agent, ip_address, last_used_at, scope
+`
+
+type RotateRefreshTokenParams struct {
+	ReplacedBy   uuid.UUID
+	LookupPrefix string
+	TokenHash    string
+}
+
+func (q *Queries) RotateRefreshToken(ctx context.Context, arg RotateRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, rotateRefreshToken, arg.ReplacedBy, arg.LookupPrefix, arg.TokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.ID,
+		&i.LookupPrefix,
+		&i.TokenHash,
+		&i.ReplacedBy,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+		&i.Scope,
 	)
 	return i, err
 }
@@ -76,10 +309,15 @@ UPDATE refresh_tokens
 SET 
 revoked_at = NOW(),
 updated_at = NOW()
-WHERE token=$1
+WHERE lookup_prefix = $1 AND token_hash = $2
 `
 
-func (q *Queries) UpdateRefreshToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, updateRefreshToken, token)
+type UpdateRefreshTokenParams struct {
+	LookupPrefix string
+	TokenHash    string
+}
+
+func (q *Queries) UpdateRefreshToken(ctx context.Context, arg UpdateRefreshTokenParams) error {
+	_, err := q.db.ExecContext(ctx, updateRefreshToken, arg.LookupPrefix, arg.TokenHash)
 	return err
 }
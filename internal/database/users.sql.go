@@ -7,29 +7,49 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const bumpUserTokenVersion = `-- name: BumpUserTokenVersion :one
+UPDATE users
+SET
+token_version = token_version + 1,
+updated_at = NOW()
+WHERE id=$1
+RETURNING token_version
+`
+
+func (q *Queries) BumpUserTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, bumpUserTokenVersion, id)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 )
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after
 `
 
 type CreateUserParams struct {
 	Email          string
 	HashedPassword string
+	Handle         string
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword)
+	row := q.db.QueryRowContext(ctx, createUser, arg.Email, arg.HashedPassword, arg.Handle)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -38,6 +58,21 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LastFailedLoginAt,
+		&i.LockedUntil,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarKey,
+		&i.DeletedAt,
+		&i.PurgeAfter,
 	)
 	return i, err
 }
@@ -52,7 +87,7 @@ func (q *Queries) DeleteUsers(ctx context.Context) error {
 }
 
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users 
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after FROM users 
 WHERE email=$1
 `
 
@@ -66,6 +101,315 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LastFailedLoginAt,
+		&i.LockedUntil,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarKey,
+		&i.DeletedAt,
+		&i.PurgeAfter,
+	)
+	return i, err
+}
+
+const getUserByHandle = `-- name: GetUserByHandle :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after FROM users
+WHERE lower(handle) = lower($1) AND deleted_at IS NULL
+`
+
+func (q *Queries) GetUserByHandle(ctx context.Context, lower string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByHandle, lower)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LastFailedLoginAt,
+		&i.LockedUntil,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarKey,
+		&i.DeletedAt,
+		&i.PurgeAfter,
+	)
+	return i, err
+}
+
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after FROM users
+WHERE id=$1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LastFailedLoginAt,
+		&i.LockedUntil,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarKey,
+		&i.DeletedAt,
+		&i.PurgeAfter,
+	)
+	return i, err
+}
+
+const getUserTokenVersion = `-- name: GetUserTokenVersion :one
+SELECT token_version FROM users
+WHERE id=$1
+`
+
+func (q *Queries) GetUserTokenVersion(ctx context.Context, id uuid.UUID) (int32, error) {
+	row := q.db.QueryRowContext(ctx, getUserTokenVersion, id)
+	var token_version int32
+	err := row.Scan(&token_version)
+	return token_version, err
+}
+
+const getUsersByIDs = `-- name: GetUsersByIDs :many
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after FROM users
+WHERE id = ANY($1::uuid[]) AND deleted_at IS NULL
+`
+
+func (q *Queries) GetUsersByIDs(ctx context.Context, ids []uuid.UUID) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersByIDs, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.IsChirpyRed,
+			&i.TokenVersion,
+			&i.Role,
+			&i.TotpSecret,
+			&i.TotpEnabledAt,
+			&i.TotpLastStep,
+			&i.EmailVerifiedAt,
+			&i.FailedLoginCount,
+			&i.LastFailedLoginAt,
+			&i.LockedUntil,
+			&i.Handle,
+			&i.DisplayName,
+			&i.Bio,
+			&i.AvatarKey,
+			&i.DeletedAt,
+			&i.PurgeAfter,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listUsersToPurge = `-- name: ListUsersToPurge :many
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after FROM users
+WHERE purge_after <= NOW()
+ORDER BY purge_after
+LIMIT $1
+`
+
+func (q *Queries) ListUsersToPurge(ctx context.Context, limit int32) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsersToPurge, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.HashedPassword,
+			&i.IsChirpyRed,
+			&i.TokenVersion,
+			&i.Role,
+			&i.TotpSecret,
+			&i.TotpEnabledAt,
+			&i.TotpLastStep,
+			&i.EmailVerifiedAt,
+			&i.FailedLoginCount,
+			&i.LastFailedLoginAt,
+			&i.LockedUntil,
+			&i.Handle,
+			&i.DisplayName,
+			&i.Bio,
+			&i.AvatarKey,
+			&i.DeletedAt,
+			&i.PurgeAfter,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markEmailVerified = `-- name: MarkEmailVerified :one
+UPDATE users
+SET
+email_verified_at = NOW(),
+updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after
+`
+
+func (q *Queries) MarkEmailVerified(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, markEmailVerified, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LastFailedLoginAt,
+		&i.LockedUntil,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarKey,
+		&i.DeletedAt,
+		&i.PurgeAfter,
+	)
+	return i, err
+}
+
+const purgeUser = `-- name: PurgeUser :execrows
+DELETE FROM users
+WHERE id = $1 AND purge_after <= NOW()
+`
+
+func (q *Queries) PurgeUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, purgeUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const restoreUser = `-- name: RestoreUser :execrows
+UPDATE users
+SET
+deleted_at = NULL,
+purge_after = NULL,
+updated_at = NOW()
+WHERE id = $1 AND (purge_after IS NULL OR purge_after > NOW())
+`
+
+func (q *Queries) RestoreUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, restoreUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const softDeleteUser = `-- name: SoftDeleteUser :one
+UPDATE users
+SET
+deleted_at = NOW(),
+purge_after = $2,
+updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after
+`
+
+type SoftDeleteUserParams struct {
+	ID         uuid.UUID
+	PurgeAfter sql.NullTime
+}
+
+func (q *Queries) SoftDeleteUser(ctx context.Context, arg SoftDeleteUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, softDeleteUser, arg.ID, arg.PurgeAfter)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LastFailedLoginAt,
+		&i.LockedUntil,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarKey,
+		&i.DeletedAt,
+		&i.PurgeAfter,
 	)
 	return i, err
 }
@@ -76,7 +420,7 @@ SET updated_at=NOW(),
 hashed_password=$1,
 email=$2
 WHERE id=$3
-RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after
 `
 
 type UpdateUserParams struct {
@@ -95,6 +439,222 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LastFailedLoginAt,
+		&i.LockedUntil,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarKey,
+		&i.DeletedAt,
+		&i.PurgeAfter,
+	)
+	return i, err
+}
+
+const updateUserAvatar = `-- name: UpdateUserAvatar :one
+UPDATE users
+SET
+avatar_key = $2,
+updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after
+`
+
+type UpdateUserAvatarParams struct {
+	ID        uuid.UUID
+	AvatarKey sql.NullString
+}
+
+func (q *Queries) UpdateUserAvatar(ctx context.Context, arg UpdateUserAvatarParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserAvatar, arg.ID, arg.AvatarKey)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LastFailedLoginAt,
+		&i.LockedUntil,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarKey,
+		&i.DeletedAt,
+		&i.PurgeAfter,
+	)
+	return i, err
+}
+
+const updateUserEmail = `-- name: UpdateUserEmail :one
+UPDATE users
+SET
+email = $2,
+email_verified_at = NULL,
+updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after
+`
+
+type UpdateUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+func (q *Queries) UpdateUserEmail(ctx context.Context, arg UpdateUserEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserEmail, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LastFailedLoginAt,
+		&i.LockedUntil,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarKey,
+		&i.DeletedAt,
+		&i.PurgeAfter,
+	)
+	return i, err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users
+SET
+hashed_password = $2,
+updated_at = NOW()
+WHERE id = $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
+const updateUserProfile = `-- name: UpdateUserProfile :one
+UPDATE users
+SET
+handle = $2,
+display_name = $3,
+bio = $4,
+updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after
+`
+
+type UpdateUserProfileParams struct {
+	ID          uuid.UUID
+	Handle      string
+	DisplayName string
+	Bio         string
+}
+
+func (q *Queries) UpdateUserProfile(ctx context.Context, arg UpdateUserProfileParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserProfile,
+		arg.ID,
+		arg.Handle,
+		arg.DisplayName,
+		arg.Bio,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LastFailedLoginAt,
+		&i.LockedUntil,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarKey,
+		&i.DeletedAt,
+		&i.PurgeAfter,
+	)
+	return i, err
+}
+
+const updateUserRole = `-- name: UpdateUserRole :one
+UPDATE users
+SET
+role = $2,
+token_version = token_version + 1,
+updated_at = NOW()
+WHERE id=$1
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, token_version, role, totp_secret, totp_enabled_at, totp_last_step, email_verified_at, failed_login_count, last_failed_login_at, locked_until, handle, display_name, bio, avatar_key, deleted_at, purge_after
+`
+
+type UpdateUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
+}
+
+func (q *Queries) UpdateUserRole(ctx context.Context, arg UpdateUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUserRole, arg.ID, arg.Role)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.TokenVersion,
+		&i.Role,
+		&i.TotpSecret,
+		&i.TotpEnabledAt,
+		&i.TotpLastStep,
+		&i.EmailVerifiedAt,
+		&i.FailedLoginCount,
+		&i.LastFailedLoginAt,
+		&i.LockedUntil,
+		&i.Handle,
+		&i.DisplayName,
+		&i.Bio,
+		&i.AvatarKey,
+		&i.DeletedAt,
+		&i.PurgeAfter,
 	)
 	return i, err
 }
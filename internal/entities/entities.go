@@ -0,0 +1,125 @@
+package entities
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	TypeHashtag = "hashtag"
+	TypeMention = "mention"
+	TypeURL     = "url"
+)
+
+// Entity is a hashtag, mention or URL found in a chirp body. Start and End are
+// byte offsets into the body, RuneStart and RuneEnd the same span in runes.
+type Entity struct {
+	Type       string `json:"type"`
+	Text       string `json:"text"`
+	Normalized string `json:"normalized"`
+	Start      int    `json:"start"`
+	End        int    `json:"end"`
+	RuneStart  int    `json:"rune_start"`
+	RuneEnd    int    `json:"rune_end"`
+}
+
+var (
+	urlPattern     = regexp.MustCompile(`https?://[^\s<>"]+`)
+	hashtagPattern = regexp.MustCompile(`#[\p{L}\p{N}_]+`)
+	mentionPattern = regexp.MustCompile(`@[A-Za-z0-9_]{1,30}`)
+)
+
+// Extract returns the entities in body ordered by position. Hashtags and
+// mentions inside URLs are ignored.
+func Extract(body string) []Entity {
+	found := []Entity{}
+
+	for _, loc := range urlPattern.FindAllStringIndex(body, -1) {
+		start, end := loc[0], loc[1]
+		end = start + len(strings.TrimRight(body[start:end], ".,;:!?)]}'"))
+		found = append(found, Entity{
+			Type:       TypeURL,
+			Text:       body[start:end],
+			Normalized: body[start:end],
+			Start:      start,
+			End:        end,
+		})
+	}
+
+	for _, loc := range hashtagPattern.FindAllStringIndex(body, -1) {
+		start, end := loc[0], loc[1]
+		tag := body[start+1 : end]
+		if !precededByBoundary(body, start) || !hasLetter(tag) || inside(found, start) {
+			continue
+		}
+		found = append(found, Entity{
+			Type:       TypeHashtag,
+			Text:       body[start:end],
+			Normalized: NormalizeHashtag(tag),
+			Start:      start,
+			End:        end,
+		})
+	}
+
+	for _, loc := range mentionPattern.FindAllStringIndex(body, -1) {
+		start, end := loc[0], loc[1]
+		// skip e-mail addresses and anything glued to a longer handle
+		if !precededByBoundary(body, start) || inside(found, start) {
+			continue
+		}
+		if next, _ := utf8.DecodeRuneInString(body[end:]); end < len(body) && isWordRune(next) {
+			continue
+		}
+		found = append(found, Entity{
+			Type:       TypeMention,
+			Text:       body[start:end],
+			Normalized: strings.ToLower(body[start+1 : end]),
+			Start:      start,
+			End:        end,
+		})
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].Start < found[j].Start })
+	for i := range found {
+		found[i].RuneStart = utf8.RuneCountInString(body[:found[i].Start])
+		found[i].RuneEnd = found[i].RuneStart + utf8.RuneCountInString(found[i].Text)
+	}
+	return found
+}
+
+func NormalizeHashtag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(tag, "#"))
+}
+
+func precededByBoundary(body string, start int) bool {
+	if start == 0 {
+		return true
+	}
+	prev, _ := utf8.DecodeLastRuneInString(body[:start])
+	return !isWordRune(prev) && prev != '&' && prev != '.'
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func hasLetter(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func inside(found []Entity, pos int) bool {
+	for _, e := range found {
+		if pos >= e.Start && pos < e.End {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package entities
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []Entity
+	}{
+		{
+			name: "no entities",
+			in:   "just a chirp",
+			want: []Entity{},
+		},
+		{
+			name: "hashtag and mention",
+			in:   "hi @Bob, #GoLang rocks",
+			want: []Entity{
+				{Type: TypeMention, Text: "@Bob", Normalized: "bob", Start: 3, End: 7, RuneStart: 3, RuneEnd: 7},
+				{Type: TypeHashtag, Text: "#GoLang", Normalized: "golang", Start: 9, End: 16, RuneStart: 9, RuneEnd: 16},
+			},
+		},
+		{
+			name: "rune offsets differ from byte offsets",
+			in:   "привет #мир",
+			want: []Entity{
+				{Type: TypeHashtag, Text: "#мир", Normalized: "мир", Start: 13, End: 20, RuneStart: 7, RuneEnd: 11},
+			},
+		},
+		{
+			name: "url swallows fragment and trailing punctuation is dropped",
+			in:   "see https://example.com/a#b.",
+			want: []Entity{
+				{Type: TypeURL, Text: "https://example.com/a#b", Normalized: "https://example.com/a#b", Start: 4, End: 27, RuneStart: 4, RuneEnd: 27},
+			},
+		},
+		{
+			name: "emails, numeric tags and mid-word markers are ignored",
+			in:   "mail me@example.com #123 a#b",
+			want: []Entity{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.want, Extract(tc.in))
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	msg := Message{To: "walt@example.com", Subject: "Reset", Body: "line one\nline two"}
+	got := string(format("chirpy@example.com", msg, time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)))
+
+	require.Contains(t, got, "From: chirpy@example.com\r\n")
+	require.Contains(t, got, "To: walt@example.com\r\n")
+	require.Contains(t, got, "Subject: Reset\r\n")
+	require.Contains(t, got, "Date: Thu, 02 Jan 2025 03:04:05 +0000\r\n")
+	require.True(t, strings.HasSuffix(got, "\r\n\r\nline one\r\nline two"))
+}
+
+func TestHeaderInjection(t *testing.T) {
+	m := &MemoryMailer{}
+	err := m.Send(context.Background(), Message{To: "a@example.com\r\nBcc: b@example.com", Subject: "hi"})
+	require.Error(t, err)
+	require.Empty(t, m.Sent())
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := &MemoryMailer{}
+	require.NoError(t, m.Send(context.Background(), Message{To: "a@example.com", Subject: "one"}))
+	require.NoError(t, m.Send(context.Background(), Message{To: "b@example.com", Subject: "two"}))
+
+	sent := m.Sent()
+	require.Len(t, sent, 2)
+	require.Equal(t, "two", sent[1].Subject)
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	m := FileMailer{Dir: dir, From: "chirpy@example.com"}
+	require.NoError(t, m.Send(context.Background(), Message{To: "a@example.com", Subject: "hello", Body: "body"}))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.True(t, strings.HasSuffix(entries[0].Name(), "-a@example.com.eml"))
+
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+	require.Contains(t, string(data), "Subject: hello")
+}
@@ -0,0 +1,240 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+type Action string
+
+const (
+	ActionMask   Action = "mask"
+	ActionHold   Action = "hold"
+	ActionReject Action = "reject"
+)
+
+// severity orders actions so the strongest matched rule decides what happens to a chirp.
+var severity = map[Action]int{
+	"":           0,
+	ActionMask:   1,
+	ActionHold:   2,
+	ActionReject: 3,
+}
+
+func ParseAction(s string) (Action, error) {
+	a := Action(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := severity[a]; !ok || a == "" {
+		return "", fmt.Errorf("unknown moderation action %q", s)
+	}
+	return a, nil
+}
+
+type Rule struct {
+	Pattern string
+	Action  Action
+}
+
+// Source supplies rules; the filter merges every source on each reload.
+type Source interface {
+	Rules(ctx context.Context) ([]Rule, error)
+}
+
+type SourceFunc func(ctx context.Context) ([]Rule, error)
+
+func (f SourceFunc) Rules(ctx context.Context) ([]Rule, error) {
+	return f(ctx)
+}
+
+// FileSource reads one rule per line as "<action> <word>" or just "<word>"
+// (masked). Blank lines and lines starting with # are ignored.
+type FileSource struct {
+	Path string
+}
+
+func (s FileSource) Rules(ctx context.Context) ([]Rule, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	rules := []Rule{}
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		switch len(fields) {
+		case 1:
+			rules = append(rules, Rule{Pattern: fields[0], Action: ActionMask})
+		case 2:
+			action, err := ParseAction(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", s.Path, line, err)
+			}
+			rules = append(rules, Rule{Pattern: fields[1], Action: action})
+		default:
+			return nil, fmt.Errorf("%s:%d: expected \"<action> <word>\"", s.Path, line)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+type Match struct {
+	Word string `json:"word"`
+	Rule Rule   `json:"-"`
+}
+
+type Result struct {
+	Body    string
+	Action  Action
+	Matches []Match
+}
+
+// Filter checks chirps against the current rule set. Rules are swapped
+// atomically on Reload, so checks never block on a reload in progress.
+type Filter struct {
+	sources []Source
+	rules   atomic.Pointer[map[string]Rule]
+}
+
+func NewFilter(sources ...Source) *Filter {
+	f := &Filter{sources: sources}
+	f.rules.Store(&map[string]Rule{})
+	return f
+}
+
+func (f *Filter) Reload(ctx context.Context) error {
+	rules := map[string]Rule{}
+	for _, source := range f.sources {
+		loaded, err := source.Rules(ctx)
+		if err != nil {
+			return err
+		}
+		for _, rule := range loaded {
+			key := Normalize(rule.Pattern)
+			if key == "" {
+				continue
+			}
+			if existing, ok := rules[key]; ok && severity[existing.Action] >= severity[rule.Action] {
+				continue
+			}
+			rules[key] = rule
+		}
+	}
+	f.rules.Store(&rules)
+	return nil
+}
+
+// Watch reloads the rules every interval until ctx is cancelled. A failed
+// reload keeps the previous rules.
+func (f *Filter) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := f.Reload(ctx); err != nil {
+				log.Printf("Error reloading moderation rules: %s", err)
+			}
+		}
+	}
+}
+
+// Check masks matched words in body and reports the strongest action of all matches.
+// Whitespace and the punctuation around a masked word are preserved.
+func (f *Filter) Check(body string) Result {
+	rules := *f.rules.Load()
+	result := Result{}
+	out := strings.Builder{}
+
+	rest := body
+	for len(rest) > 0 {
+		r, size := utf8.DecodeRuneInString(rest)
+		if unicode.IsSpace(r) {
+			out.WriteString(rest[:size])
+			rest = rest[size:]
+			continue
+		}
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end == -1 {
+			end = len(rest)
+		}
+		word := rest[:end]
+		rest = rest[end:]
+
+		key := Normalize(word)
+		rule, ok := rules[key]
+		if !ok {
+			// "kerfuffle!" — the ! is punctuation here, not leet for i
+			key = Normalize(strings.TrimFunc(word, unicode.IsPunct))
+			rule, ok = rules[key]
+		}
+		if !ok {
+			out.WriteString(word)
+			continue
+		}
+		result.Matches = append(result.Matches, Match{Word: word, Rule: rule})
+		if severity[rule.Action] > severity[result.Action] {
+			result.Action = rule.Action
+		}
+
+		// "(kerfuffle)," keeps its brackets and comma, but in "@wful" the @
+		// is part of the word and gets masked with it
+		core := strings.TrimLeftFunc(word, unicode.IsPunct)
+		prefix := word[:len(word)-len(core)]
+		core = strings.TrimRightFunc(core, unicode.IsPunct)
+		suffix := word[len(prefix)+len(core):]
+		if Normalize(core) != key {
+			prefix, suffix = "", ""
+		}
+		out.WriteString(prefix)
+		out.WriteString("****")
+		out.WriteString(suffix)
+	}
+
+	result.Body = out.String()
+	return result
+}
+
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+	'|': 'l',
+}
+
+// Normalize lowercases a word, undoes common leet-speak substitutions and
+// drops punctuation, so "K3rfuff!e" and "kerfuffle." both become "kerfuffle".
+func Normalize(word string) string {
+	out := strings.Builder{}
+	for _, r := range strings.ToLower(word) {
+		if sub, ok := leet[r]; ok {
+			r = sub
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
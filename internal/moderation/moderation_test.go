@@ -0,0 +1,98 @@
+package moderation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestFilter(t *testing.T, rules ...Rule) *Filter {
+	f := NewFilter(SourceFunc(func(ctx context.Context) ([]Rule, error) {
+		return rules, nil
+	}))
+	require.NoError(t, f.Reload(context.Background()))
+	return f
+}
+
+func TestCheck_Mask(t *testing.T) {
+	f := newTestFilter(t,
+		Rule{Pattern: "kerfuffle", Action: ActionMask},
+		Rule{Pattern: "sharbert", Action: ActionMask},
+		Rule{Pattern: "fornax", Action: ActionMask},
+		Rule{Pattern: "awful", Action: ActionMask},
+	)
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "no profane words", in: "Hello world", want: "Hello world"},
+		{name: "censors regardless of case", in: "Kerfuffle is bad", want: "**** is bad"},
+		{name: "multiple profane words", in: "sharbert fornax ok", want: "**** **** ok"},
+		{name: "keeps whitespace", in: "  hello   kerfuffle  ", want: "  hello   ****  "},
+		{name: "punctuation", in: "what a kerfuffle!", want: "what a ****!"},
+		{name: "keeps surrounding punctuation", in: "(fornax), $harbert.", want: "(****), ****."},
+		{name: "leet punctuation is part of the word", in: "@wful", want: "****"},
+		{name: "leet speak", in: "k3rfuff|3 and f0rn4x", want: "**** and ****"},
+		{name: "substring is not a match", in: "kerfuffles", want: "kerfuffles"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := f.Check(tc.in)
+			require.Equal(t, tc.want, got.Body)
+		})
+	}
+}
+
+func TestCheck_StrongestActionWins(t *testing.T) {
+	f := newTestFilter(t,
+		Rule{Pattern: "mild", Action: ActionMask},
+		Rule{Pattern: "spicy", Action: ActionHold},
+		Rule{Pattern: "awful", Action: ActionReject},
+	)
+
+	require.Equal(t, Action(""), f.Check("all good").Action)
+	require.Equal(t, ActionMask, f.Check("mild").Action)
+	require.Equal(t, ActionHold, f.Check("mild and spicy").Action)
+	require.Equal(t, ActionReject, f.Check("spicy awful mild").Action)
+	require.Len(t, f.Check("spicy awful mild").Matches, 3)
+}
+
+func TestFileSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# comment\nkerfuffle\nreject fornax\n\nhold sharbert\n"), 0o600))
+
+	rules, err := FileSource{Path: path}.Rules(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []Rule{
+		{Pattern: "kerfuffle", Action: ActionMask},
+		{Pattern: "fornax", Action: ActionReject},
+		{Pattern: "sharbert", Action: ActionHold},
+	}, rules)
+
+	require.NoError(t, os.WriteFile(path, []byte("explode fornax\n"), 0o600))
+	_, err = FileSource{Path: path}.Rules(context.Background())
+	require.Error(t, err)
+}
+
+func TestReload_KeepsOldRulesOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	require.NoError(t, os.WriteFile(path, []byte("kerfuffle\n"), 0o600))
+
+	f := NewFilter(FileSource{Path: path})
+	require.NoError(t, f.Reload(context.Background()))
+	require.Equal(t, "****", f.Check("kerfuffle").Body)
+
+	require.NoError(t, os.WriteFile(path, []byte("fornax\n"), 0o600))
+	require.NoError(t, f.Reload(context.Background()))
+	require.Equal(t, "kerfuffle ****", f.Check("kerfuffle fornax").Body)
+
+	require.NoError(t, os.Remove(path))
+	require.Error(t, f.Reload(context.Background()))
+	require.Equal(t, "kerfuffle ****", f.Check("kerfuffle fornax").Body)
+}
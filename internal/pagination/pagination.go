@@ -0,0 +1,72 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Cursor points at the last row of a page; the next page starts strictly after it
+// in (created_at, id) order, or (rank, created_at, id) for ranked results.
+type Cursor struct {
+	Rank      *float32  `json:"r,omitempty"`
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+}
+
+func (c Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("cursor is malformed: %w", err)
+	}
+	c := Cursor{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Cursor{}, fmt.Errorf("cursor is malformed: %w", err)
+	}
+	if c.ID == uuid.Nil || c.CreatedAt.IsZero() {
+		return Cursor{}, fmt.Errorf("cursor is incomplete")
+	}
+	return c, nil
+}
+
+func ParseLimit(s string) (int, error) {
+	if s == "" {
+		return DefaultLimit, nil
+	}
+	limit, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("limit must be a number, got %q", s)
+	}
+	if limit < 1 {
+		return 0, fmt.Errorf("limit must be positive, got %d", limit)
+	}
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+	return limit, nil
+}
+
+// NextLink builds an RFC 8288 Link header value pointing at the next page,
+// which is u with its cursor replaced by next.
+func NextLink(u *url.URL, next string) string {
+	nextURL := *u
+	query := nextURL.Query()
+	query.Set("cursor", next)
+	nextURL.RawQuery = query.Encode()
+	return fmt.Sprintf("<%s>; rel=\"next\"", nextURL.RequestURI())
+}
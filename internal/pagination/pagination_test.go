@@ -0,0 +1,89 @@
+package pagination
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := Cursor{
+		CreatedAt: time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC),
+		ID:        uuid.New(),
+	}
+
+	got, err := DecodeCursor(c.Encode())
+	require.NoError(t, err)
+	require.True(t, c.CreatedAt.Equal(got.CreatedAt))
+	require.Equal(t, c.ID, got.ID)
+}
+
+func TestCursorRoundTrip_Rank(t *testing.T) {
+	rank := float32(0.0607927)
+	c := Cursor{
+		Rank:      &rank,
+		CreatedAt: time.Date(2024, 5, 1, 12, 30, 0, 0, time.UTC),
+		ID:        uuid.New(),
+	}
+
+	got, err := DecodeCursor(c.Encode())
+	require.NoError(t, err)
+	require.NotNil(t, got.Rank)
+	require.Equal(t, rank, *got.Rank)
+}
+
+func TestDecodeCursor_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor string
+	}{
+		{name: "not base64", cursor: "!!!"},
+		{name: "not json", cursor: "bm90LWpzb24"},
+		{name: "empty object", cursor: "e30"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := DecodeCursor(tc.cursor)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{name: "default", in: "", want: DefaultLimit},
+		{name: "explicit", in: "5", want: 5},
+		{name: "clamped", in: "1000", want: MaxLimit},
+		{name: "zero", in: "0", wantErr: true},
+		{name: "garbage", in: "ten", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseLimit(tc.in)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestNextLink(t *testing.T) {
+	u, err := url.Parse("/api/chirps?author_id=abc&cursor=old&limit=10")
+	require.NoError(t, err)
+
+	link := NextLink(u, "new")
+	require.Equal(t, `</api/chirps?author_id=abc&cursor=new&limit=10>; rel="next"`, link)
+}
@@ -0,0 +1,110 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// PrefixLen is the length of the hash prefix in a range query.
+const PrefixLen = 5
+
+// FileSource serves range queries from a local copy of the Pwned Passwords
+// list: one upper or lower case SHA-1 hex hash per line, optionally followed
+// by ":count", sorted by hash. Lookups binary search the file, so even the
+// full list needs no memory and no index.
+type FileSource struct {
+	f    *os.File
+	size int64
+}
+
+func OpenFile(path string) (*FileSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FileSource{f: f, size: info.Size()}, nil
+}
+
+func (s *FileSource) Close() error {
+	return s.f.Close()
+}
+
+func (s *FileSource) Range(ctx context.Context, prefix string) ([]string, error) {
+	if len(prefix) != PrefixLen {
+		return nil, fmt.Errorf("range prefix must be %d characters, got %q", PrefixLen, prefix)
+	}
+	prefix = strings.ToUpper(prefix)
+
+	// find the first line whose hash sorts at or after prefix
+	lo, hi := int64(0), s.size
+	for lo < hi {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		mid := lo + (hi-lo)/2
+		hash, err := s.hashAt(mid)
+		if err != nil {
+			return nil, err
+		}
+		if hash == "" || hash >= prefix {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	r := s.linesFrom(lo)
+	var suffixes []string
+	for {
+		line, err := r.ReadString('\n')
+		hash := lineHash(line)
+		if strings.HasPrefix(hash, prefix) {
+			suffixes = append(suffixes, hash[PrefixLen:])
+		} else if hash != "" {
+			break
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return suffixes, nil
+}
+
+// hashAt returns the hash on the first line that starts at or after off, or
+// "" past the last line.
+func (s *FileSource) hashAt(off int64) (string, error) {
+	r := s.linesFrom(off)
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return lineHash(line), nil
+}
+
+// linesFrom reads from the first line that starts at or after off.
+func (s *FileSource) linesFrom(off int64) *bufio.Reader {
+	if off == 0 {
+		return bufio.NewReader(io.NewSectionReader(s.f, 0, s.size))
+	}
+	// start one byte early so a line beginning exactly at off isn't skipped
+	r := bufio.NewReader(io.NewSectionReader(s.f, off-1, s.size-off+1))
+	r.ReadString('\n')
+	return r
+}
+
+func lineHash(line string) string {
+	hash, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+	return strings.ToUpper(hash)
+}
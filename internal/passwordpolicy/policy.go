@@ -0,0 +1,116 @@
+// Package passwordpolicy decides whether a new password is acceptable and
+// explains every rule it breaks.
+package passwordpolicy
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	CodeTooShort      = "too_short"
+	CodeTooLong       = "too_long"
+	CodeBreached      = "breached"
+	CodeContainsEmail = "contains_email"
+)
+
+// MaxLength bounds the bytes fed to the password hash.
+const MaxLength = 256
+
+// Violation is one broken rule, with a stable code for clients and a message
+// for people.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// A RangeSource answers Pwned Passwords style k-anonymity queries: given the
+// first 5 hex characters of a SHA-1 hash, it returns the remaining 35
+// characters of every breached password hash with that prefix. Only the
+// prefix is ever handed to the source.
+type RangeSource interface {
+	Range(ctx context.Context, prefix string) ([]string, error)
+}
+
+type Policy struct {
+	MinLength int
+	// Breached is consulted when set.
+	Breached RangeSource
+}
+
+// Check returns every rule password breaks for the account with email, or
+// nil when it is acceptable.
+func (p Policy) Check(ctx context.Context, password, email string) ([]Violation, error) {
+	var violations []Violation
+
+	if n := utf8.RuneCountInString(password); n < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooShort,
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		})
+	}
+	if len(password) > MaxLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooLong,
+			Message: fmt.Sprintf("Password must be at most %d bytes long", MaxLength),
+		})
+	}
+	if containsEmail(password, email) {
+		violations = append(violations, Violation{
+			Code:    CodeContainsEmail,
+			Message: "Password must not contain your email address",
+		})
+	}
+
+	if p.Breached != nil && password != "" {
+		breached, err := IsBreached(ctx, p.Breached, password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, Violation{
+				Code:    CodeBreached,
+				Message: "Password has appeared in a data breach, choose another one",
+			})
+		}
+	}
+
+	return violations, nil
+}
+
+// containsEmail reports whether password includes the email address or its
+// local part, ignoring case. Very short local parts are not checked, as they
+// would rule out too many passwords by accident.
+func containsEmail(password, email string) bool {
+	if email == "" {
+		return false
+	}
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+	if strings.Contains(password, email) {
+		return true
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return utf8.RuneCountInString(local) >= 4 && strings.Contains(password, local)
+}
+
+// IsBreached looks password up in source by the prefix of its SHA-1 hash.
+func IsBreached(ctx context.Context, source RangeSource, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := source.Range(ctx, hash[:PrefixLen])
+	if err != nil {
+		return false, err
+	}
+	for _, suffix := range suffixes {
+		if strings.EqualFold(suffix, hash[PrefixLen:]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,107 @@
+package passwordpolicy
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// writeList writes a sorted Pwned Passwords style file for passwords, plus
+// filler hashes so that lookups have neighbours on both sides.
+func writeList(t *testing.T, passwords ...string) string {
+	t.Helper()
+	var lines []string
+	for _, p := range passwords {
+		lines = append(lines, sha1Hex(p)+":42")
+	}
+	for i := 0; i < 200; i++ {
+		lines = append(lines, sha1Hex(strings.Repeat("x", i+1))+":1")
+	}
+	sort.Strings(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600))
+	return path
+}
+
+func TestFileSource(t *testing.T) {
+	path := writeList(t, "password", "123456", "hunter2")
+	source, err := OpenFile(path)
+	require.NoError(t, err)
+	defer source.Close()
+
+	ctx := context.Background()
+	for _, p := range []string{"password", "123456", "hunter2", "x", strings.Repeat("x", 200)} {
+		breached, err := IsBreached(ctx, source, p)
+		require.NoError(t, err)
+		require.True(t, breached, "%q is on the list", p)
+	}
+
+	for _, p := range []string{"correct horse battery staple", "", "hunter3"} {
+		breached, err := IsBreached(ctx, source, p)
+		require.NoError(t, err)
+		require.False(t, breached, "%q is not on the list", p)
+	}
+
+	hash := sha1Hex("password")
+	suffixes, err := source.Range(ctx, strings.ToLower(hash[:PrefixLen]))
+	require.NoError(t, err)
+	require.Contains(t, suffixes, hash[PrefixLen:])
+
+	_, err = source.Range(ctx, "ABC")
+	require.Error(t, err)
+}
+
+func TestFileSourceEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.txt")
+	require.NoError(t, os.WriteFile(path, nil, 0o600))
+	source, err := OpenFile(path)
+	require.NoError(t, err)
+	defer source.Close()
+
+	breached, err := IsBreached(context.Background(), source, "password")
+	require.NoError(t, err)
+	require.False(t, breached)
+}
+
+func TestCheck(t *testing.T) {
+	source, err := OpenFile(writeList(t, "password123"))
+	require.NoError(t, err)
+	defer source.Close()
+
+	p := Policy{MinLength: 10, Breached: source}
+	ctx := context.Background()
+
+	codes := func(password, email string) []string {
+		violations, err := p.Check(ctx, password, email)
+		require.NoError(t, err)
+		var got []string
+		for _, v := range violations {
+			require.NotEmpty(t, v.Message)
+			got = append(got, v.Code)
+		}
+		return got
+	}
+
+	require.Empty(t, codes("correct horse battery staple", "walt@example.com"))
+	require.Equal(t, []string{CodeTooShort}, codes("short", "walt@example.com"))
+	require.Equal(t, []string{CodeTooShort}, codes("", "walt@example.com"))
+	require.Equal(t, []string{CodeBreached}, codes("password123", "walt@example.com"))
+	require.Equal(t, []string{CodeContainsEmail}, codes("my-WALT@example.com!", "walt@example.com"))
+	require.Equal(t, []string{CodeContainsEmail}, codes("walterwhite99", "walterwhite@example.com"))
+	require.Empty(t, codes("alligator pie!", "al@example.com"), "short local parts are not checked")
+	require.Equal(t, []string{CodeTooLong}, codes(strings.Repeat("a", MaxLength+1), "walt@example.com"))
+	require.Equal(t, []string{CodeTooShort}, codes("ééééé", "walt@example.com"), "length counts characters")
+}
@@ -0,0 +1,93 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ToTSQuery turns a user supplied search string into a to_tsquery expression.
+// "quoted phrases" must match in order, a trailing * makes a prefix match,
+// a leading - excludes the term and everything else is ANDed together.
+func ToTSQuery(q string) (string, error) {
+	terms := []string{}
+	for _, token := range tokenize(q) {
+		negate := false
+		if !token.phrase && strings.HasPrefix(token.text, "-") {
+			negate = true
+			token.text = strings.TrimLeft(token.text, "-")
+		}
+		prefix := false
+		if !token.phrase && strings.HasSuffix(token.text, "*") {
+			prefix = true
+			token.text = strings.TrimRight(token.text, "*")
+		}
+
+		lexemes := lexemes(token.text)
+		if len(lexemes) == 0 {
+			continue
+		}
+		if prefix {
+			lexemes[len(lexemes)-1] += ":*"
+		}
+
+		term := strings.Join(lexemes, " <-> ")
+		if len(lexemes) > 1 {
+			term = "(" + term + ")"
+		}
+		if negate {
+			term = "!" + term
+		}
+		terms = append(terms, term)
+	}
+
+	if len(terms) == 0 {
+		return "", fmt.Errorf("search query is empty")
+	}
+	return strings.Join(terms, " & "), nil
+}
+
+type token struct {
+	text   string
+	phrase bool
+}
+
+func tokenize(q string) []token {
+	tokens := []token{}
+	current := strings.Builder{}
+	inPhrase := false
+	flush := func(phrase bool) {
+		if current.Len() > 0 {
+			tokens = append(tokens, token{text: current.String(), phrase: phrase})
+			current.Reset()
+		}
+	}
+
+	for _, r := range q {
+		switch {
+		case r == '"':
+			flush(inPhrase)
+			inPhrase = !inPhrase
+		case unicode.IsSpace(r) && !inPhrase:
+			flush(false)
+		default:
+			current.WriteRune(r)
+		}
+	}
+	// an unterminated quote still searches for the words as a phrase
+	flush(inPhrase)
+	return tokens
+}
+
+// lexemes splits on anything that is not a letter or digit, so nothing in the
+// result can be mistaken for tsquery syntax.
+func lexemes(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := make([]string, len(words))
+	for i, word := range words {
+		out[i] = "'" + word + "'"
+	}
+	return out
+}
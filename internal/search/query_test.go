@@ -0,0 +1,38 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestToTSQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{name: "single word", in: "Chirpy", want: "'chirpy'"},
+		{name: "words are ANDed", in: "hello  world", want: "'hello' & 'world'"},
+		{name: "phrase", in: `"hello world" again`, want: "('hello' <-> 'world') & 'again'"},
+		{name: "prefix", in: "chirp*", want: "'chirp':*"},
+		{name: "negation", in: "go -java", want: "'go' & !'java'"},
+		{name: "punctuation is dropped", in: "it's & | !", want: "('it' <-> 's')"},
+		{name: "unterminated phrase", in: `"lonely words`, want: "('lonely' <-> 'words')"},
+		{name: "empty", in: "   ", wantErr: true},
+		{name: "only operators", in: "& ! *", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ToTSQuery(tc.in)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.want, got)
+		})
+	}
+}
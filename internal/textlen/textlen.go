@@ -0,0 +1,44 @@
+package textlen
+
+import (
+	"github.com/cvrs3d/webserv/internal/entities"
+	"github.com/rivo/uniseg"
+)
+
+const (
+	// URLWeight is what every URL counts for, whatever its real length.
+	URLWeight = 23
+	// StandardLimit and RedLimit are the maximum chirp lengths per tier.
+	StandardLimit = 140
+	RedLimit      = 280
+)
+
+// Limit returns the maximum chirp length for a user of the given tier.
+func Limit(isChirpyRed bool) int {
+	if isChirpyRed {
+		return RedLimit
+	}
+	return StandardLimit
+}
+
+// Weighted measures a chirp body: grapheme clusters outside URLs count one
+// each and every URL counts URLWeight.
+func Weighted(body string) int {
+	length := 0
+	offset := 0
+	for _, e := range entities.Extract(body) {
+		if e.Type != entities.TypeURL {
+			continue
+		}
+		length += Graphemes(body[offset:e.Start]) + URLWeight
+		offset = e.End
+	}
+	return length + Graphemes(body[offset:])
+}
+
+// Graphemes counts user-perceived characters, the extended grapheme clusters
+// of UAX #29: an emoji with its modifiers, a flag or a letter with its
+// combining marks each count once.
+func Graphemes(s string) int {
+	return uniseg.GraphemeClusterCount(s)
+}
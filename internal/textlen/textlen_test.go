@@ -0,0 +1,61 @@
+package textlen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphemes(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want int
+	}{
+		{name: "empty", in: "", want: 0},
+		{name: "ascii", in: "hello", want: 5},
+		{name: "cyrillic", in: "привет", want: 6},
+		{name: "combining accent", in: "cafe\u0301", want: 4},
+		{name: "emoji with variation selector", in: "❤️", want: 1},
+		{name: "skin tone", in: "👍🏽", want: 1},
+		{name: "zwj family", in: "👨‍👩‍👧‍👦", want: 1},
+		{name: "flags", in: "🇺🇦🇵🇱", want: 2},
+		{name: "odd regional indicator", in: "🇺🇦🇵", want: 2},
+		{name: "hangul syllables", in: "한국어", want: 3},
+		{name: "hangul jamo", in: "\u1100\u1161\u11a8", want: 1},
+		{name: "crlf", in: "a\r\nb", want: 3},
+		{name: "prepend", in: "\u0600\u0661", want: 1},
+		{name: "zwj without emoji", in: "a\u200db", want: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, Graphemes(tt.in))
+		})
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want int
+	}{
+		{name: "no urls", in: "hi 👋", want: 4},
+		{name: "url only", in: "https://example.com/" + strings.Repeat("a", 100), want: URLWeight},
+		{name: "text around url", in: "see https://go.dev now", want: 4 + URLWeight + 4},
+		{name: "two urls", in: "http://a.io http://b.io", want: 2*URLWeight + 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, Weighted(tt.in))
+		})
+	}
+}
+
+func TestLimit(t *testing.T) {
+	require.Equal(t, StandardLimit, Limit(false))
+	require.Equal(t, RedLimit, Limit(true))
+}
@@ -0,0 +1,44 @@
+// Package throttle computes exponential backoff for repeated failures, such
+// as wrong passwords, so that each extra attempt costs the caller more time.
+package throttle
+
+import "time"
+
+type Policy struct {
+	// FreeAttempts is how many failures are allowed before any delay.
+	FreeAttempts int
+	// Base is the delay after the first failure past FreeAttempts. It
+	// doubles with every further failure.
+	Base time.Duration
+	// Max caps the delay.
+	Max time.Duration
+	// Window is how long a failure is remembered. A failure after a quiet
+	// period this long starts counting from one again.
+	Window time.Duration
+}
+
+// Backoff returns how long to block after failures consecutive failures, or
+// zero while they are still within FreeAttempts.
+func (p Policy) Backoff(failures int) time.Duration {
+	over := failures - p.FreeAttempts - 1
+	if over < 0 {
+		return 0
+	}
+
+	d := p.Base
+	for i := 0; i < over; i++ {
+		d *= 2
+		if d >= p.Max {
+			return p.Max
+		}
+	}
+	if d > p.Max {
+		return p.Max
+	}
+	return d
+}
+
+// Locks reports whether failures is the first failure that blocks.
+func (p Policy) Locks(failures int) bool {
+	return failures == p.FreeAttempts+1
+}
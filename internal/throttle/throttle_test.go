@@ -0,0 +1,38 @@
+package throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	p := Policy{FreeAttempts: 3, Base: time.Minute, Max: 10 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 3, want: 0},
+		{failures: 4, want: time.Minute},
+		{failures: 5, want: 2 * time.Minute},
+		{failures: 6, want: 4 * time.Minute},
+		{failures: 7, want: 8 * time.Minute},
+		{failures: 8, want: 10 * time.Minute},
+		{failures: 1000, want: 10 * time.Minute},
+	}
+
+	for _, tc := range tests {
+		require.Equal(t, tc.want, p.Backoff(tc.failures), "failures=%d", tc.failures)
+	}
+}
+
+func TestLocks(t *testing.T) {
+	p := Policy{FreeAttempts: 3, Base: time.Minute, Max: time.Hour}
+
+	require.False(t, p.Locks(3))
+	require.True(t, p.Locks(4))
+	require.False(t, p.Locks(5), "only the transition into lockout should notify")
+}
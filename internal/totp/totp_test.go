@@ -0,0 +1,83 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHOTPRFC6238Vectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "94287082"},
+		{unix: 1111111109, want: "07081804"},
+		{unix: 1111111111, want: "14050471"},
+		{unix: 1234567890, want: "89005924"},
+		{unix: 2000000000, want: "69279037"},
+		{unix: 20000000000, want: "65353130"},
+	}
+
+	for _, tt := range tests {
+		step := Step(time.Unix(tt.unix, 0))
+		require.Equal(t, tt.want, hotp(key, uint64(step), 8), "T=%d", tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	now := time.Unix(1700000000, 0)
+
+	code, err := Code(secret, now)
+	require.NoError(t, err)
+	require.Len(t, code, Digits)
+
+	step, ok := Validate(secret, code, now)
+	require.True(t, ok)
+	require.Equal(t, Step(now), step)
+
+	_, ok = Validate(secret, code, now.Add(Period))
+	require.True(t, ok, "one step of drift is allowed")
+
+	_, ok = Validate(secret, code, now.Add(3*Period))
+	require.False(t, ok)
+
+	_, ok = Validate(secret, code[:3]+" "+code[3:], now)
+	require.True(t, ok, "spaces are ignored")
+
+	_, ok = Validate(secret, "12345", now)
+	require.False(t, ok)
+
+	_, ok = Validate(strings.ToLower(secret), code, now)
+	require.True(t, ok)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Chirpy", "walt@example.com", "JBSWY3DPEHPK3PXP")
+	require.True(t, strings.HasPrefix(uri, "otpauth://totp/Chirpy:walt@example.com?"))
+	require.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	require.Contains(t, uri, "issuer=Chirpy")
+}
+
+func TestSealOpen(t *testing.T) {
+	sealed, err := Seal([]byte("key"), "JBSWY3DPEHPK3PXP")
+	require.NoError(t, err)
+	require.NotContains(t, sealed, "JBSWY3DPEHPK3PXP")
+
+	secret, err := Open([]byte("key"), sealed)
+	require.NoError(t, err)
+	require.Equal(t, "JBSWY3DPEHPK3PXP", secret)
+
+	_, err = Open([]byte("other"), sealed)
+	require.Error(t, err)
+
+	_, err = Seal(nil, "JBSWY3DPEHPK3PXP")
+	require.ErrorIs(t, err, ErrEmptyKey)
+	_, err = Open(nil, sealed)
+	require.ErrorIs(t, err, ErrEmptyKey)
+}
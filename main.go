@@ -1,13 +1,24 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/cvrs3d/webserv/internal/auth"
+	"github.com/cvrs3d/webserv/internal/blob"
 	"github.com/cvrs3d/webserv/internal/database"
+	"github.com/cvrs3d/webserv/internal/mail"
+	"github.com/cvrs3d/webserv/internal/moderation"
+	"github.com/cvrs3d/webserv/internal/passwordpolicy"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
@@ -20,20 +31,206 @@ func main() {
 	dbURL := os.Getenv("DB_URL")
 	platform := os.Getenv("PLATFORM")
 	secret := os.Getenv("PRIVATE_KEY")
+	var keys *auth.Keyring
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		k, err := auth.LoadKeyring(dir, os.Getenv("JWT_SIGNING_KID"))
+		if err != nil {
+			log.Fatalf("Error loading JWT keys from %s: %s", dir, err)
+		}
+		keys = k
+	} else {
+		// a throwaway key logs everyone out on restart and isn't shared
+		// between replicas, which is only acceptable in development
+		if platform != "dev" {
+			log.Fatal("JWT_KEYS_DIR must be set unless PLATFORM=dev")
+		}
+		log.Println("JWT_KEYS_DIR not set, signing access tokens with a throwaway key")
+		k, err := auth.GenerateKeyring()
+		if err != nil {
+			log.Fatal(err)
+		}
+		keys = k
+	}
+	refreshTokenKey := os.Getenv("REFRESH_TOKEN_KEY")
+	if refreshTokenKey == "" {
+		// hashes under a known or empty key would let a database leak be
+		// turned back into working tokens
+		if platform != "dev" {
+			log.Fatal("REFRESH_TOKEN_KEY must be set unless PLATFORM=dev")
+		}
+		log.Println("REFRESH_TOKEN_KEY not set, hashing refresh tokens with PRIVATE_KEY")
+		refreshTokenKey = secret
+	}
+	mfaKey := os.Getenv("MFA_KEY")
+	if mfaKey == "" {
+		if platform != "dev" {
+			log.Fatal("MFA_KEY must be set unless PLATFORM=dev")
+		}
+		log.Println("MFA_KEY not set, encrypting TOTP secrets with PRIVATE_KEY")
+		mfaKey = secret
+	}
 	polkaAPIKey := os.Getenv("POLKA_KEY")
+	editWindow := 30 * time.Minute
+	if s := os.Getenv("CHIRP_EDIT_WINDOW"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatalf("Invalid CHIRP_EDIT_WINDOW %q: %s", s, err)
+		}
+		editWindow = d
+	}
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "Chirpy <no-reply@chirpy.local>"
+	}
+	var mailer mail.Mailer
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		mailer = mail.SMTPMailer{
+			Addr: addr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From: mailFrom,
+		}
+	} else {
+		mailDir := os.Getenv("MAIL_DIR")
+		if mailDir == "" {
+			mailDir = "mail"
+		}
+		log.Printf("SMTP_ADDR not set, writing outgoing mail to %s", mailDir)
+		mailer = mail.FileMailer{Dir: mailDir, From: mailFrom}
+	}
+	passwordResetURL := os.Getenv("PASSWORD_RESET_URL")
+	if passwordResetURL == "" {
+		passwordResetURL = "http://localhost:8080/app/reset-password"
+	}
+	emailVerificationURL := os.Getenv("EMAIL_VERIFICATION_URL")
+	if emailVerificationURL == "" {
+		emailVerificationURL = "http://localhost:8080/api/verify-email"
+	}
+	accountUnlockURL := os.Getenv("ACCOUNT_UNLOCK_URL")
+	if accountUnlockURL == "" {
+		accountUnlockURL = "http://localhost:8080/api/unlock-account"
+	}
+	requireVerifiedEmail := false
+	if s := os.Getenv("REQUIRE_VERIFIED_EMAIL"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			log.Fatalf("Invalid REQUIRE_VERIFIED_EMAIL %q: %s", s, err)
+		}
+		requireVerifiedEmail = b
+	}
+	passwordParams := *auth.DefaultPasswordParams
+	passwordParams.Memory = uint32(envUint("ARGON2_MEMORY_KIB", uint64(passwordParams.Memory), 32))
+	passwordParams.Iterations = uint32(envUint("ARGON2_ITERATIONS", uint64(passwordParams.Iterations), 32))
+	passwordParams.Parallelism = uint8(envUint("ARGON2_PARALLELISM", uint64(passwordParams.Parallelism), 8))
+	if passwordParams.Iterations == 0 || passwordParams.Parallelism == 0 {
+		log.Fatal("ARGON2_ITERATIONS and ARGON2_PARALLELISM must be at least 1")
+	}
+	passwordPolicy := passwordpolicy.Policy{
+		MinLength: int(envUint("PASSWORD_MIN_LENGTH", 8, 16)),
+	}
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		source, err := passwordpolicy.OpenFile(path)
+		if err != nil {
+			log.Fatalf("Error opening BREACHED_PASSWORDS_FILE: %s", err)
+		}
+		defer source.Close()
+		passwordPolicy.Breached = source
+	}
+	blobDir := os.Getenv("BLOB_DIR")
+	if blobDir == "" {
+		blobDir = "uploads"
+	}
+	accountDeletionGrace := 30 * 24 * time.Hour
+	if s := os.Getenv("ACCOUNT_DELETION_GRACE"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid ACCOUNT_DELETION_GRACE %q", s)
+		}
+		accountDeletionGrace = d
+	}
+	purgeInterval := time.Hour
+	if s := os.Getenv("PURGE_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid PURGE_INTERVAL %q", s)
+		}
+		purgeInterval = d
+	}
+	var trustedProxies []netip.Prefix
+	for _, s := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(s)
+			if addrErr != nil {
+				log.Fatalf("Invalid TRUSTED_PROXIES entry %q: %s", s, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
 	db, err := sql.Open("postgres", dbURL)
 	if err != nil {
 		log.Fatal(err)
 	}
 	dbQueries := database.New(db)
 	log.Println("Connection established: ", dbQueries)
+
+	if len(os.Args) > 1 {
+		if err := runCommand(context.Background(), dbQueries, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	apiCfg := apiConfig{
 		fileserverHits: atomic.Int32{},
+		conn: db,
 		db: dbQueries,
 		platform: platform,
 		secret: secret,
+		keys: keys,
+		mfaKey: []byte(mfaKey),
+		refreshTokenKey: []byte(refreshTokenKey),
 		polkaAPIKey: polkaAPIKey,
+		editWindow: editWindow,
+		mailer: mailer,
+		passwordResetURL: passwordResetURL,
+		emailVerificationURL: emailVerificationURL,
+		requireVerifiedEmail: requireVerifiedEmail,
+		accountUnlockURL: accountUnlockURL,
+		passwordParams: &passwordParams,
+		passwordPolicy: passwordPolicy,
+		blobs: blob.FileStore{Dir: blobDir},
+		accountDeletionGrace: accountDeletionGrace,
+		trustedProxies: trustedProxies,
+	}
+
+	// hash once up front so the first login for an unknown email isn't slower
+	dummyPasswordHash(apiCfg.passwordParams)
+
+	moderationSources := []moderation.Source{moderation.SourceFunc(apiCfg.moderationRules)}
+	if path := os.Getenv("MODERATION_RULES_FILE"); path != "" {
+		moderationSources = append(moderationSources, moderation.FileSource{Path: path})
 	}
+	moderationReload := time.Minute
+	if s := os.Getenv("MODERATION_RELOAD_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid MODERATION_RELOAD_INTERVAL %q", s)
+		}
+		moderationReload = d
+	}
+	apiCfg.moderator = moderation.NewFilter(moderationSources...)
+	if err := apiCfg.moderator.Reload(context.Background()); err != nil {
+		log.Printf("Error loading moderation rules: %s", err)
+	}
+	go apiCfg.moderator.Watch(context.Background(), moderationReload)
+	go apiCfg.purgeLoop(context.Background(), purgeInterval)
+
 	multiplexer := http.NewServeMux()
 
 	multiplexer.Handle("/app/", apiCfg.middlewareMetrics(http.StripPrefix("/app/", http.FileServer(http.Dir(".")))))
@@ -42,21 +239,69 @@ func main() {
 	multiplexer.Handle("/app/assets", apiCfg.middlewareMetrics(assetsHandler))
 	multiplexer.Handle("/app/assets/", apiCfg.middlewareMetrics(assetsHandler))
 
+	multiplexer.HandleFunc("GET /media/{key...}", apiCfg.mediaHandler)
+
 	multiplexer.HandleFunc("GET /api/healthz", healthHandler)
-	multiplexer.HandleFunc("GET /api/chirps/{chirp_id}", apiCfg.getChirpByIDHandler)
-	multiplexer.HandleFunc("GET /admin/metrics", apiCfg.metricsHandler)
-	multiplexer.HandleFunc("GET /api/chirps", apiCfg.getChirpsHandler)
+	multiplexer.HandleFunc("GET /.well-known/jwks.json", apiCfg.jwksHandler)
+	multiplexer.HandleFunc("GET /api/chirps/{chirp_id}", apiCfg.middlewareOptionalAuth(apiCfg.getChirpByIDHandler))
+	multiplexer.HandleFunc("GET /api/chirps/{chirp_id}/thread", apiCfg.middlewareOptionalAuth(apiCfg.getChirpThreadHandler))
+	multiplexer.HandleFunc("GET /api/chirps/{chirp_id}/revisions", apiCfg.getChirpRevisionsHandler)
+	multiplexer.HandleFunc("GET /admin/metrics", apiCfg.middlewareRequirePermission(auth.PermViewMetrics, apiCfg.metricsHandler))
+	multiplexer.HandleFunc("GET /admin/moderation/rules", apiCfg.middlewareRequirePermission(auth.PermModerate, apiCfg.listModerationRulesHandler))
+	multiplexer.HandleFunc("GET /admin/moderation/held", apiCfg.middlewareRequirePermission(auth.PermModerate, apiCfg.listHeldChirpsHandler))
+	multiplexer.HandleFunc("GET /api/chirps", apiCfg.middlewareOptionalAuth(apiCfg.getChirpsHandler))
+	multiplexer.HandleFunc("GET /api/timeline", apiCfg.middlewareRequireAuth(auth.ScopeChirpsRead, apiCfg.timelineHandler))
+	multiplexer.HandleFunc("GET /api/search/chirps", apiCfg.middlewareOptionalAuth(apiCfg.searchChirpsHandler))
+	multiplexer.HandleFunc("GET /api/hashtags/trending", apiCfg.trendingHashtagsHandler)
+	multiplexer.HandleFunc("GET /api/hashtags/{tag}/chirps", apiCfg.middlewareOptionalAuth(apiCfg.getHashtagChirpsHandler))
+	multiplexer.HandleFunc("GET /api/users/{handle}", apiCfg.getUserProfileHandler)
+	multiplexer.HandleFunc("GET /api/users/{user_id}/followers", apiCfg.getFollowersHandler)
+	multiplexer.HandleFunc("GET /api/users/{user_id}/following", apiCfg.getFollowingHandler)
 
-	multiplexer.HandleFunc("POST /admin/reset", apiCfg.resetHandler)
+	multiplexer.HandleFunc("POST /admin/reset", apiCfg.middlewareRequirePermission(auth.PermResetData, apiCfg.resetHandler))
+	multiplexer.HandleFunc("PUT /admin/users/{user_id}/role", apiCfg.middlewareRequirePermission(auth.PermManageRoles, apiCfg.updateUserRoleHandler))
+	multiplexer.HandleFunc("POST /admin/moderation/rules", apiCfg.middlewareRequirePermission(auth.PermModerate, apiCfg.upsertModerationRuleHandler))
+	multiplexer.HandleFunc("POST /admin/moderation/held/{held_id}/approve", apiCfg.middlewareRequirePermission(auth.PermModerate, apiCfg.approveHeldChirpHandler))
+	multiplexer.HandleFunc("POST /admin/moderation/held/{held_id}/reject", apiCfg.middlewareRequirePermission(auth.PermModerate, apiCfg.rejectHeldChirpHandler))
 	multiplexer.HandleFunc("POST /api/users", apiCfg.usersHandler)
+	multiplexer.HandleFunc("GET /api/verify-email", apiCfg.verifyEmailHandler)
+	multiplexer.HandleFunc("POST /api/verify-email/resend", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.resendVerificationHandler))
 	multiplexer.HandleFunc("POST /api/login", apiCfg.loginHandler)
+	multiplexer.HandleFunc("GET /api/unlock-account", apiCfg.unlockAccountHandler)
+	multiplexer.HandleFunc("POST /api/login/mfa", apiCfg.loginMFAHandler)
+	multiplexer.HandleFunc("POST /api/mfa/totp", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.enrollTOTPHandler))
+	multiplexer.HandleFunc("POST /api/mfa/totp/confirm", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.confirmTOTPHandler))
+	multiplexer.HandleFunc("POST /api/mfa/recovery-codes", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.regenerateRecoveryCodesHandler))
+	multiplexer.HandleFunc("POST /api/password-reset/request", apiCfg.requestPasswordResetHandler)
+	multiplexer.HandleFunc("POST /api/password-reset/confirm", apiCfg.confirmPasswordResetHandler)
 	multiplexer.HandleFunc("POST /api/refresh", apiCfg.refreshHandler)
 	multiplexer.HandleFunc("POST /api/revoke", apiCfg.revokeHandler)
-	multiplexer.HandleFunc("POST /api/chirps", apiCfg.validateHandler)
+	multiplexer.HandleFunc("POST /api/logout-all", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.logoutAllHandler))
+	multiplexer.HandleFunc("POST /api/users/me/export", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.requestDataExportHandler))
+	multiplexer.HandleFunc("GET /api/sessions", apiCfg.middlewareRequireAuth(auth.ScopeAccountRead, apiCfg.listSessionsHandler))
+	multiplexer.HandleFunc("GET /api/users/me/exports/{export_id}", apiCfg.middlewareRequireAuth(auth.ScopeAccountRead, apiCfg.getDataExportHandler))
+	multiplexer.HandleFunc("GET /api/users/me/exports/{export_id}/download", apiCfg.middlewareRequireAuth(auth.ScopeAccountRead, apiCfg.downloadDataExportHandler))
+	multiplexer.HandleFunc("DELETE /api/mfa/totp", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.disableTOTPHandler))
+	multiplexer.HandleFunc("DELETE /api/sessions/{session_id}", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.deleteSessionHandler))
+	multiplexer.HandleFunc("POST /api/chirps", apiCfg.middlewareRequireAuth(auth.ScopeChirpsWrite, apiCfg.validateHandler))
+	multiplexer.HandleFunc("POST /api/users/{user_id}/follow", apiCfg.middlewareRequireAuth(auth.ScopeSocialWrite, apiCfg.followHandler))
+	multiplexer.HandleFunc("POST /api/chirps/{chirp_id}/likes", apiCfg.middlewareRequireAuth(auth.ScopeSocialWrite, apiCfg.likeChirpHandler))
+	multiplexer.HandleFunc("POST /api/chirps/{chirp_id}/rechirp", apiCfg.middlewareRequireAuth(auth.ScopeChirpsWrite, apiCfg.rechirpHandler))
+	multiplexer.HandleFunc("POST /api/chirps/{chirp_id}/reactions", apiCfg.middlewareRequireAuth(auth.ScopeSocialWrite, apiCfg.addReactionHandler))
 
-	multiplexer.HandleFunc("PUT /api/users", apiCfg.updateUserHandler)
+	multiplexer.HandleFunc("PUT /api/users/me/avatar", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.uploadAvatarHandler))
+	multiplexer.HandleFunc("DELETE /api/users/me/avatar", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.deleteAvatarHandler))
+	multiplexer.HandleFunc("DELETE /api/users/me", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.deleteAccountHandler))
+	multiplexer.HandleFunc("PATCH /api/users", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.updateUserHandler))
+	multiplexer.HandleFunc("PUT /api/users", apiCfg.middlewareRequireAuth(auth.ScopeAccountWrite, apiCfg.replaceUserHandler))
+	multiplexer.HandleFunc("PUT /api/chirps/{chirp_id}", apiCfg.middlewareRequireAuth(auth.ScopeChirpsWrite, apiCfg.editChirpHandler))
 	
-	multiplexer.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.deleteChirpByIDHandler)
+	multiplexer.HandleFunc("DELETE /api/chirps/{chirpID}", apiCfg.middlewareRequireAuth(auth.ScopeChirpsWrite, apiCfg.deleteChirpByIDHandler))
+	multiplexer.HandleFunc("DELETE /admin/moderation/rules/{rule_id}", apiCfg.middlewareRequirePermission(auth.PermModerate, apiCfg.deleteModerationRuleHandler))
+	multiplexer.HandleFunc("DELETE /api/users/{user_id}/follow", apiCfg.middlewareRequireAuth(auth.ScopeSocialWrite, apiCfg.unfollowHandler))
+	multiplexer.HandleFunc("DELETE /api/chirps/{chirp_id}/likes", apiCfg.middlewareRequireAuth(auth.ScopeSocialWrite, apiCfg.unlikeChirpHandler))
+	multiplexer.HandleFunc("DELETE /api/chirps/{chirp_id}/rechirp", apiCfg.middlewareRequireAuth(auth.ScopeChirpsWrite, apiCfg.undoRechirpHandler))
+	multiplexer.HandleFunc("DELETE /api/chirps/{chirp_id}/reactions/{emoji}", apiCfg.middlewareRequireAuth(auth.ScopeSocialWrite, apiCfg.removeReactionHandler))
 	multiplexer.HandleFunc("POST /api/polka/webhooks", apiCfg.polkaWebhookHandler)
 	
 
@@ -76,3 +321,48 @@ func main() {
 		log.Fatal("Error: ", err)
 	}
 }
+
+// envUint reads a non-negative integer of at most bits bits from the
+// environment, or returns def when the variable is unset.
+func envUint(name string, def uint64, bits int) uint64 {
+	s := os.Getenv(name)
+	if s == "" {
+		return def
+	}
+	n, err := strconv.ParseUint(s, 10, bits)
+	if err != nil {
+		log.Fatalf("Invalid %s %q: %s", name, s, err)
+	}
+	return n
+}
+
+// runCommand handles the administrative subcommands, e.g.
+//
+//	chirpy grant-role admin@example.com admin
+//
+// which is how the first admin gets created.
+func runCommand(ctx context.Context, db *database.Queries, args []string) error {
+	switch args[0] {
+	case "grant-role":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: grant-role <email> <user|moderator|admin>")
+		}
+		email, role := args[1], args[2]
+		if !auth.ValidRole(role) {
+			return fmt.Errorf("unknown role %q", role)
+		}
+		user, err := db.GetUserByEmail(ctx, email)
+		if err != nil {
+			return fmt.Errorf("looking up %s: %w", email, err)
+		}
+		if _, err := db.UpdateUserRole(ctx, database.UpdateUserRoleParams{
+			ID: user.ID,
+			Role: role,
+		}); err != nil {
+			return err
+		}
+		log.Printf("%s is now %s", email, role)
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", args[0])
+}
@@ -1,27 +1,58 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
-	"sort"
+	"net/netip"
+	"path"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/cvrs3d/webserv/internal/auth"
+	"github.com/cvrs3d/webserv/internal/blob"
 	"github.com/cvrs3d/webserv/internal/database"
+	"github.com/cvrs3d/webserv/internal/entities"
+	"github.com/cvrs3d/webserv/internal/mail"
+	"github.com/cvrs3d/webserv/internal/moderation"
+	"github.com/cvrs3d/webserv/internal/pagination"
+	"github.com/cvrs3d/webserv/internal/passwordpolicy"
+	"github.com/cvrs3d/webserv/internal/search"
+	"github.com/cvrs3d/webserv/internal/textlen"
+	"github.com/cvrs3d/webserv/internal/throttle"
+	"github.com/cvrs3d/webserv/internal/totp"
 	"github.com/google/uuid"
 )
 
 type apiConfig struct {
 	fileserverHits atomic.Int32
+	conn *sql.DB
 	db *database.Queries
 	platform string
 	secret string
+	keys *auth.Keyring
+	mfaKey []byte
+	refreshTokenKey []byte
 	polkaAPIKey string
+	editWindow time.Duration
+	moderator *moderation.Filter
+	mailer mail.Mailer
+	passwordResetURL string
+	emailVerificationURL string
+	requireVerifiedEmail bool
+	accountUnlockURL string
+	passwordParams *auth.PasswordParams
+	passwordPolicy passwordpolicy.Policy
+	blobs blob.Store
+	accountDeletionGrace time.Duration
+	trustedProxies []netip.Prefix
 }
 
 func (cfg *apiConfig) middlewareMetrics(next http.Handler) http.Handler {
@@ -31,6 +62,109 @@ func (cfg *apiConfig) middlewareMetrics(next http.Handler) http.Handler {
 	})
 }
 
+type principalKey struct{}
+
+func principalFromContext(ctx context.Context) (auth.Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(auth.Principal)
+	return principal, ok
+}
+
+// requestPrincipal is the caller of a route behind middlewareRequireAuth.
+func requestPrincipal(r *http.Request) auth.Principal {
+	principal, _ := principalFromContext(r.Context())
+	return principal
+}
+
+// authenticate validates the bearer access token, if any. ok is false when
+// no token was sent at all.
+func (cfg *apiConfig) authenticate(r *http.Request) (principal auth.Principal, ok bool, err error) {
+	if r.Header.Get("Authorization") == "" {
+		return auth.Principal{}, false, nil
+	}
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return auth.Principal{}, true, err
+	}
+	principal, err = auth.ValidateJWT(token, cfg.keys, cfg.tokenVersion(r.Context()))
+	return principal, true, err
+}
+
+// challenge responds with an RFC 6750 Bearer challenge. errorCode is empty
+// when no token was presented.
+func challenge(w http.ResponseWriter, code int, errorCode, scope, msg string) {
+	value := `Bearer realm="chirpy"`
+	if errorCode != "" {
+		value += fmt.Sprintf(`, error=%q`, errorCode)
+	}
+	if scope != "" {
+		value += fmt.Sprintf(`, scope=%q`, scope)
+	}
+	w.Header().Set("WWW-Authenticate", value)
+	respondWithError(w, code, msg)
+}
+
+// middlewareRequireAuth only lets requests with a valid access token through,
+// and when scope is not empty the token must have been granted it.
+func (cfg *apiConfig) middlewareRequireAuth(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok, err := cfg.authenticate(r)
+		if !ok {
+			challenge(w, 401, "", "", "Access token is not present")
+			return
+		}
+		if err != nil {
+			log.Printf("Token not valid: %s", err)
+			challenge(w, 401, "invalid_token", "", "Access token is not valid")
+			return
+		}
+		if scope != "" && !principal.HasScope(scope) {
+			challenge(w, 403, "insufficient_scope", scope, fmt.Sprintf("Access token is missing the %s scope", scope))
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	}
+}
+
+// requirePermission responds with 403 unless one of the caller's roles grants
+// perm. It can be used inside any handler behind middlewareRequireAuth.
+func requirePermission(w http.ResponseWriter, r *http.Request, perm auth.Permission) bool {
+	principal := requestPrincipal(r)
+	if !principal.Can(perm) {
+		log.Printf("User %s lacks permission %s", principal.UserID, perm)
+		respondWithError(w, 403, "Not authorized")
+		return false
+	}
+	return true
+}
+
+// middlewareRequirePermission guards a whole route with requirePermission.
+func (cfg *apiConfig) middlewareRequirePermission(perm auth.Permission, next http.HandlerFunc) http.HandlerFunc {
+	return cfg.middlewareRequireAuth("", func(w http.ResponseWriter, r *http.Request) {
+		if !requirePermission(w, r, perm) {
+			return
+		}
+		next(w, r)
+	})
+}
+
+// middlewareOptionalAuth lets anonymous requests through but still rejects a
+// token that is present and invalid, so clients learn they need to refresh.
+func (cfg *apiConfig) middlewareOptionalAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		principal, ok, err := cfg.authenticate(r)
+		if !ok {
+			next(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("Token not valid: %s", err)
+			challenge(w, 401, "invalid_token", "", "Access token is not valid")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	}
+}
+
 func (cfg *apiConfig) metricsHandler(w http.ResponseWriter, r *http.Request) {
 	value := cfg.fileserverHits.Load()
 	w.Header().Set("Content-type", "text/html")
@@ -64,6 +198,7 @@ func (cfg *apiConfig) usersHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Email string `json:"email"`
 		Password string `json:"password"`
+		Handle string `json:"handle"`
 	}
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -73,16 +208,47 @@ func (cfg *apiConfig) usersHandler(w http.ResponseWriter, r *http.Request) {
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
-	password_hash, _ := auth.HashPassword(params.Password)
+	if params.Email == "" {
+		respondWithError(w, 400, "Email is required")
+		return
+	}
+	if params.Handle == "" {
+		params.Handle, err = generateHandle()
+		if err != nil {
+			log.Printf("Error generating handle: %s", err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+	} else if !validHandle(params.Handle) {
+		respondWithError(w, 400, "Handle must be 3 to 30 letters, digits or underscores")
+		return
+	}
+	if !cfg.checkPassword(w, r, params.Password, params.Email) {
+		return
+	}
+	password_hash, err := auth.HashPasswordWithParams(params.Password, cfg.passwordParams)
+	if err != nil {
+		log.Printf("Error hashing password: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
 	userDTO, err := cfg.db.CreateUser(r.Context(), database.CreateUserParams{
 		Email: params.Email,
 		HashedPassword: password_hash,
+		Handle: params.Handle,
 	})
+	if respondUserConflict(w, err) {
+		return
+	}
 	if err != nil {
 		log.Printf("Error making querry: %s", err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
+	if err := cfg.sendEmailVerification(r.Context(), userDTO); err != nil {
+		// The account is usable anyway; the user can ask for another email.
+		log.Printf("Error sending verification email to user %s: %s", userDTO.ID, err)
+	}
 	user := MapUserDTOToUser(userDTO)
 	respondWithJSON(w, 201, user)
 }
@@ -91,21 +257,11 @@ func (cfg *apiConfig) validateHandler(w http.ResponseWriter, r *http.Request) {
 	type parameters struct {
 		Body string `json:"body"`
 		UserID uuid.UUID `json:"user_id"`
+		InReplyTo *uuid.UUID `json:"in_reply_to,omitempty"`
+		QuoteOf *uuid.UUID `json:"quote_of,omitempty"`
 	}
 	
-	token, err := auth.GetBearerToken(r.Header)
-	if err != nil {
-		log.Printf("Error getting bearer roken: %s", err)
-		respondWithError(w, 401, "Missing header")
-		return
-	}
-
-	user_id, err := auth.ValidateJWT(token, cfg.secret)
-	if err != nil {
-		log.Printf("Token not valid: %s", err)
-		respondWithError(w, 401, "Auth token is not valid")
-		return	
-	}
+	user_id := requestPrincipal(r).UserID
 
 	decoder := json.NewDecoder(r.Body)
 	params := parameters{}
@@ -115,16 +271,88 @@ func (cfg *apiConfig) validateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(params.Body) > 140 {
-		respondWithError(w, 400, "Chirp is too long")
+	userDTO, err := cfg.db.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", user_id, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if cfg.requireVerifiedEmail && !userDTO.EmailVerifiedAt.Valid {
+		respondWithError(w, 403, "Verify your email address before posting")
+		return
+	}
+
+	if !checkChirpLength(w, params.Body, userDTO.IsChirpyRed.Bool) {
+		return
+	}
+
+	inReplyTo := uuid.NullUUID{}
+	if params.InReplyTo != nil {
+		parentDTO, err := cfg.getOriginalChirp(r.Context(), *params.InReplyTo)
+		if err == sql.ErrNoRows {
+			respondWithError(w, 404, "Parent chirp not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Error retrieving parent chirp %s: %s", params.InReplyTo, err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+		if parentDTO.DeletedAt.Valid {
+			respondWithError(w, 400, "Cannot reply to a deleted chirp")
+			return
+		}
+		inReplyTo = uuid.NullUUID{UUID: parentDTO.ID, Valid: true}
+	}
+
+	quoteOf := uuid.NullUUID{}
+	if params.QuoteOf != nil {
+		quotedDTO, err := cfg.getOriginalChirp(r.Context(), *params.QuoteOf)
+		if err == sql.ErrNoRows {
+			respondWithError(w, 404, "Quoted chirp not found")
+			return
+		}
+		if err != nil {
+			log.Printf("Error retrieving quoted chirp %s: %s", params.QuoteOf, err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+		if quotedDTO.DeletedAt.Valid {
+			respondWithError(w, 400, "Cannot quote a deleted chirp")
+			return
+		}
+		quoteOf = uuid.NullUUID{UUID: quotedDTO.ID, Valid: true}
+	}
+
+	moderated := cfg.moderator.Check(params.Body)
+	switch moderated.Action {
+	case moderation.ActionReject:
+		respondWithError(w, 422, "Chirp contains prohibited content")
+		return
+	case moderation.ActionHold:
+		heldDTO, err := cfg.db.CreateHeldChirp(r.Context(), database.CreateHeldChirpParams{
+			UserID: user_id,
+			Body: params.Body,
+			InReplyTo: inReplyTo,
+			QuoteOf: quoteOf,
+			Matched: matchedWords(moderated),
+		})
+		if err != nil {
+			log.Printf("Error holding chirp for review: %s", err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+		respondWithJSON(w, 202, MapHeldChirpDTOToHeldChirp(heldDTO))
 		return
 	}
 
-	chirpDTO, err := cfg.db.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body: params.Body,
+	chirpDTO, err := cfg.createChirp(r.Context(), database.CreateChirpParams{
+		Body: moderated.Body,
 		UserID: user_id,
+		InReplyTo: inReplyTo,
+		QuoteOf: quoteOf,
 	})
-
 	if err != nil {
 		log.Printf("Error creating Chirp DTO: %s, user_id used: %s", err, user_id.String())
 		respondWithError(w, 500, "Something went wrong")
@@ -133,256 +361,974 @@ func (cfg *apiConfig) validateHandler(w http.ResponseWriter, r *http.Request) {
 
 	response := MapChirpDTOToChirp(chirpDTO)
 
+	if err := cfg.decorateChirps(r.Context(), uuid.NullUUID{UUID: user_id, Valid: true}, []*Chirp{&response}, expandAuthor(r)); err != nil {
+		log.Printf("Error loading chirp engagement: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
 	respondWithJSON(w, 201, response)
 }
 
-func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, r *http.Request) {
-	s := r.URL.Query().Get("author_id")
-	sorted := r.URL.Query().Get("sort")
+// createChirp stores a chirp that has already passed moderation, together with its entities.
+func (cfg *apiConfig) createChirp(ctx context.Context, arg database.CreateChirpParams) (database.Chirp, error) {
+	tx, err := cfg.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return database.Chirp{}, err
+	}
+	defer tx.Rollback()
 
-	var (
-		chirpsDTOS []database.Chirp;
-		err error
-	)
+	chirpDTO, err := insertChirp(ctx, cfg.db.WithTx(tx), arg)
+	if err != nil {
+		return database.Chirp{}, err
+	}
 
-	if s != "" {
-		userId, _ := uuid.Parse(s)
-		chirpsDTOS, err = cfg.db.GetChirpsByAuthor(r.Context(), userId) 
+	return chirpDTO, tx.Commit()
+}
 
-	} else {
-		chirpsDTOS, err = cfg.db.GetChirps(r.Context()) 
+// insertChirp creates the chirp with its entities using q, which callers
+// bind to a transaction.
+func insertChirp(ctx context.Context, q *database.Queries, arg database.CreateChirpParams) (database.Chirp, error) {
+	chirpDTO, err := q.CreateChirp(ctx, arg)
+	if err != nil {
+		return database.Chirp{}, err
 	}
 
-	if err != nil {
-		log.Printf("Error retrieving chirps: %s", err)
-		respondWithError(w, 500, "Something went wrong")
-		return
+	if err := saveChirpEntities(ctx, q, chirpDTO); err != nil {
+		return database.Chirp{}, err
 	}
 
-	responseChirps := make([]Chirp, len(chirpsDTOS))
+	return chirpDTO, nil
+}
 
-	for i, c := range chirpsDTOS {
-		responseChirps[i] = MapChirpDTOToChirp(c)
-	}
-	if sorted == "asc" {
-		sort.Slice(responseChirps, func(i, j int) bool { return responseChirps[i].CreatedAt.Before(responseChirps[j].CreatedAt) })
-	} else {
-		sort.Slice(responseChirps, func(i, j int) bool { return responseChirps[i].CreatedAt.After(responseChirps[j].CreatedAt) })
+func matchedWords(result moderation.Result) string {
+	words := make([]string, len(result.Matches))
+	for i, m := range result.Matches {
+		words[i] = m.Word
 	}
+	return strings.Join(words, ",")
+}
 
-	respondWithJSON(w, 200, responseChirps)
+// getOriginalChirp loads a chirp, following a rechirp to the chirp it shares.
+// Chirps of accounts pending deletion count as missing.
+func (cfg *apiConfig) getOriginalChirp(ctx context.Context, id uuid.UUID) (database.Chirp, error) {
+	return originalChirp(ctx, cfg.db, id)
 }
 
-func (cfg *apiConfig) getChirpByIDHandler(w http.ResponseWriter, r *http.Request) {
-	id := r.PathValue("chirp_id")
+// originalChirp is getOriginalChirp using q, so it can run inside a transaction.
+func originalChirp(ctx context.Context, q *database.Queries, id uuid.UUID) (database.Chirp, error) {
+	chirpDTO, err := q.GetVisibleChirpByID(ctx, id)
+	if err != nil || !chirpDTO.RechirpOf.Valid {
+		return chirpDTO, err
+	}
+	return q.GetVisibleChirpByID(ctx, chirpDTO.RechirpOf.UUID)
+}
 
-	if len(id) == 0 {
-		log.Printf("Error getting chirp_id")
-		respondWithError(w, 404, "Not found")
-		return
+// saveChirpEntities indexes the hashtags, mentions and URLs of a freshly created chirp.
+func saveChirpEntities(ctx context.Context, q *database.Queries, chirpDTO database.Chirp) error {
+	var position int32
+	for _, e := range entities.Extract(chirpDTO.Body) {
+		switch e.Type {
+		case entities.TypeHashtag:
+			if err := q.CreateHashtag(ctx, e.Normalized); err != nil {
+				return err
+			}
+			if err := q.AddChirpHashtag(ctx, database.AddChirpHashtagParams{
+				ChirpID: chirpDTO.ID,
+				Tag: e.Normalized,
+			}); err != nil {
+				return err
+			}
+		case entities.TypeMention:
+			if err := q.AddChirpMention(ctx, database.AddChirpMentionParams{
+				ChirpID: chirpDTO.ID,
+				Handle: e.Normalized,
+			}); err != nil {
+				return err
+			}
+		case entities.TypeURL:
+			if err := q.AddChirpURL(ctx, database.AddChirpURLParams{
+				ChirpID: chirpDTO.ID,
+				Position: position,
+				Url: e.Normalized,
+			}); err != nil {
+				return err
+			}
+			position++
+		}
 	}
+	return nil
+}
 
-	uid, err := uuid.Parse(id)
-	if err != nil {
-		log.Printf("Error parsing uuid: %s", err)
-		respondWithError(w, 500, "Something went wrong")
-		return
+func clearChirpEntities(ctx context.Context, q *database.Queries, chirpID uuid.UUID) error {
+	if err := q.DeleteChirpHashtags(ctx, chirpID); err != nil {
+		return err
 	}
+	if err := q.DeleteChirpMentions(ctx, chirpID); err != nil {
+		return err
+	}
+	return q.DeleteChirpURLs(ctx, chirpID)
+}
 
-	chirpDTO, err := cfg.db.GetChirpByID(r.Context(), uid)
+func (cfg *apiConfig) getChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s := query.Get("author_id")
+	sorted := query.Get("sort")
+
+	page, err := parsePageParams(query)
 	if err != nil {
-		log.Printf("Error retrieving chirp: %s", err)
-		respondWithError(w, 404, "Nor found")
+		respondPageError(w, err)
 		return
 	}
 
-	response := MapChirpDTOToChirp(chirpDTO)
+	authorID := uuid.NullUUID{}
+	if s != "" {
+		userId, err := uuid.Parse(s)
+		if err != nil {
+			log.Printf("Error parsing author_id: %s", err)
+			respondWithError(w, 400, "Invalid author_id")
+			return
+		}
+		authorID = uuid.NullUUID{UUID: userId, Valid: true}
+	}
 
-	respondWithJSON(w, 200, response)
-}
+	var chirpsDTOS []database.Chirp
 
-func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
-	type parameters struct {
-		Email string `json:"email"`
-		Password string `json:"password"`
-		EIS int `json:"expires_in_seconds,omitempty"`
+	// fetch one extra row to know whether there is a next page
+	if sorted == "asc" {
+		chirpsDTOS, err = cfg.db.ListChirpsAsc(r.Context(), database.ListChirpsAscParams{
+			AuthorID: authorID,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID: page.cursorID,
+			PageLimit: int32(page.limit + 1),
+		})
+	} else {
+		chirpsDTOS, err = cfg.db.ListChirpsDesc(r.Context(), database.ListChirpsDescParams{
+			AuthorID: authorID,
+			CursorCreatedAt: page.cursorCreatedAt,
+			CursorID: page.cursorID,
+			PageLimit: int32(page.limit + 1),
+		})
 	}
 
-	decoder := json.NewDecoder(r.Body)
-	params := parameters{}
-	err := decoder.Decode(&params)
 	if err != nil {
-		log.Printf("Error decoding parameters: %s", err)
+		log.Printf("Error retrieving chirps: %s", err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
-	userDTO, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
+
+	response, err := cfg.chirpPage(w, r, chirpsDTOS, page.limit)
 	if err != nil {
-		log.Printf("Error connecting to db: %s", err)
+		log.Printf("Error loading chirp engagement: %s", err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
-	if flag, _ := auth.CheckPasswordHash(params.Password, userDTO.HashedPassword); !flag {
-		respondWithError(w, 401, "Incorrect email or password")
-		return
+
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) chirpPage(w http.ResponseWriter, r *http.Request, chirpsDTOS []database.Chirp, limit int) (ChirpPage, error) {
+	response := ChirpPage{}
+	if len(chirpsDTOS) > limit {
+		chirpsDTOS = chirpsDTOS[:limit]
+		last := chirpsDTOS[limit - 1]
+		response.NextCursor = setNextCursor(w, r, pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
 	}
 
-	if params.EIS < 1 || params.EIS > 60 {
-		params.EIS = 60
+	response.Chirps = make([]Chirp, len(chirpsDTOS))
+	chirps := make([]*Chirp, len(chirpsDTOS))
+	for i, c := range chirpsDTOS {
+		response.Chirps[i] = MapChirpDTOToChirp(c)
+		chirps[i] = &response.Chirps[i]
 	}
+	return response, cfg.decorateChirps(r.Context(), viewerID(r), chirps, expandAuthor(r))
+}
 
-	jwt, err := auth.MakeJWT(userDTO.ID, cfg.secret, time.Second * time.Duration(params.EIS))
+// viewerID returns the caller on routes behind middlewareOptionalAuth, or an
+// invalid ID for anonymous requests.
+func viewerID(r *http.Request) uuid.NullUUID {
+	principal, ok := principalFromContext(r.Context())
+	if !ok {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: principal.UserID, Valid: true}
+}
 
-	if err != nil {
-		log.Printf("Error constructing the JWT: %s", err)
-		respondWithError(w, 500, "Something went wrong")
-		return
+// decorateChirps fills in embedded rechirped/quoted chirps, reaction counts,
+// liked_by_me and, when withAuthors is set, author profiles for a batch of
+// chirps.
+func (cfg *apiConfig) decorateChirps(ctx context.Context, viewer uuid.NullUUID, chirps []*Chirp, withAuthors bool) error {
+	if len(chirps) == 0 {
+		return nil
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	embeddedIDs := []uuid.UUID{}
+	for _, c := range chirps {
+		if c.RechirpOfID != nil {
+			embeddedIDs = append(embeddedIDs, *c.RechirpOfID)
+		}
+		if c.QuoteOfID != nil {
+			embeddedIDs = append(embeddedIDs, *c.QuoteOfID)
+		}
+	}
+	if len(embeddedIDs) > 0 {
+		embeddedDTOS, err := cfg.db.GetChirpsByIDs(ctx, embeddedIDs)
+		if err != nil {
+			return err
+		}
+		embedded := make(map[uuid.UUID]Chirp, len(embeddedDTOS))
+		for _, e := range embeddedDTOS {
+			embedded[e.ID] = MapChirpDTOToChirp(e)
+		}
+		for _, c := range chirps {
+			if c.RechirpOfID != nil {
+				if e, ok := embedded[*c.RechirpOfID]; ok {
+					c.RechirpOf = &e
+					chirps = append(chirps, c.RechirpOf)
+				}
+			}
+			if c.QuoteOfID != nil {
+				if e, ok := embedded[*c.QuoteOfID]; ok {
+					c.QuoteOf = &e
+					chirps = append(chirps, c.QuoteOf)
+				}
+			}
+		}
+	}
+
+	ids := make([]uuid.UUID, len(chirps))
+	byID := make(map[uuid.UUID][]*Chirp, len(chirps))
+	for i, c := range chirps {
+		ids[i] = c.ID
+		byID[c.ID] = append(byID[c.ID], c)
+	}
+
+	if withAuthors {
+		if err := cfg.embedAuthors(ctx, chirps); err != nil {
+			return err
+		}
+	}
+
+	counts, err := cfg.db.GetReactionCounts(ctx, ids)
 	if err != nil {
-		respondWithError(w, 500, "Something went wrong")
-		log.Printf("Error generating refresh secret: %s", err)
-		return
+		return err
 	}
-	rt, err := cfg.db.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token: refreshToken,
-		UserID: userDTO.ID,
-		ExpiresAt: time.Now().Add(time.Hour * 24 * time.Duration(60)),
-		RevokedAt: sql.NullTime{},
+	for _, rc := range counts {
+		for _, c := range byID[rc.ChirpID] {
+			c.ReactionCounts[rc.Emoji] = rc.Count
+		}
+	}
+
+	if !viewer.Valid {
+		return nil
+	}
+	liked, err := cfg.db.GetLikedChirpIDs(ctx, database.GetLikedChirpIDsParams{
+		UserID: viewer.UUID,
+		ChirpIds: ids,
 	})
 	if err != nil {
-		log.Printf("Error constructing the Refresh token: %s", err)
-		respondWithError(w, 500, "Something went wrong")
-		return
+		return err
+	}
+	for _, id := range liked {
+		for _, c := range byID[id] {
+			c.LikedByMe = true
+		}
 	}
+	return nil
+}
 
-	user := MapUserDTOToUser(userDTO)
-	user.JWTToken = jwt
-	user.RefreshToken = rt.Token
+// embedAuthors attaches the public profile of each chirp's author.
+func (cfg *apiConfig) embedAuthors(ctx context.Context, chirps []*Chirp) error {
+	seen := make(map[uuid.UUID]bool, len(chirps))
+	authorIDs := []uuid.UUID{}
+	for _, c := range chirps {
+		if c.UserID != nil && !seen[*c.UserID] {
+			seen[*c.UserID] = true
+			authorIDs = append(authorIDs, *c.UserID)
+		}
+	}
 
-	respondWithJSON(w, 200, user)
+	userDTOS, err := cfg.db.GetUsersByIDs(ctx, authorIDs)
+	if err != nil {
+		return err
+	}
+	profiles := make(map[uuid.UUID]*Profile, len(userDTOS))
+	for _, u := range userDTOS {
+		profile := MapUserDTOToProfile(u)
+		profiles[u.ID] = &profile
+	}
+	for _, c := range chirps {
+		if c.UserID != nil {
+			c.Author = profiles[*c.UserID]
+		}
+	}
+	return nil
 }
 
+func (cfg *apiConfig) searchChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
 
-func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, r *http.Request) {
-	type response struct {
-		Token string `json:"token"`
+	tsQuery, err := search.ToTSQuery(query.Get("q"))
+	if err != nil {
+		log.Printf("Error parsing search query: %s", err)
+		respondWithError(w, 400, "Search query is empty")
+		return
 	}
-	token, err := auth.GetBearerToken(r.Header)
 
+	page, err := parsePageParams(query)
 	if err != nil {
-		log.Printf("Error fetching refresh token from a header: %s", err)
-		respondWithError(w, 401, "Refresh token is not present")
+		respondPageError(w, err)
 		return
 	}
-
-	tokenDTO, err := cfg.db.GetRefreshTokenByToken(r.Context(), token)
-
-	if err == sql.ErrNoRows {
-		log.Printf("Error refresh token has expired or doesn't exists: %s", err)
-		respondWithError(w, 401, "Refresh token has expired or doesn't exists")
+	if page.cursorID.Valid && !page.cursorRank.Valid {
+		respondWithError(w, 400, "cursor is not a search cursor")
 		return
 	}
 
+	hitDTOS, err := cfg.db.SearchChirps(r.Context(), database.SearchChirpsParams{
+		Query: tsQuery,
+		CursorRank: page.cursorRank,
+		CursorCreatedAt: page.cursorCreatedAt,
+		CursorID: page.cursorID,
+		PageLimit: int32(page.limit + 1),
+	})
 	if err != nil {
-		log.Printf("Error executing query: %s", err)
+		log.Printf("Error searching chirps for %q: %s", tsQuery, err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
 
-	jwt, err := auth.MakeJWT(tokenDTO.UserID, cfg.secret, time.Duration(1) * time.Hour)
+	response := SearchPage{}
+	if len(hitDTOS) > page.limit {
+		hitDTOS = hitDTOS[:page.limit]
+		last := hitDTOS[page.limit - 1]
+		response.NextCursor = setNextCursor(w, r, pagination.Cursor{Rank: &last.Rank, CreatedAt: last.CreatedAt, ID: last.ID})
+	}
 
-	if err != nil {
-		log.Printf("Error : %s", err)
+	response.Chirps = make([]SearchHit, len(hitDTOS))
+	chirps := make([]*Chirp, len(hitDTOS))
+	for i, h := range hitDTOS {
+		response.Chirps[i] = MapSearchHitDTOToSearchHit(h)
+		chirps[i] = &response.Chirps[i].Chirp
+	}
+
+	if err := cfg.decorateChirps(r.Context(), viewerID(r), chirps, expandAuthor(r)); err != nil {
+		log.Printf("Error loading chirp engagement: %s", err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
 
-	respondWithJSON(w, 200, response {
-		Token: jwt,
-	})
+	respondWithJSON(w, 200, response)
 }
 
-func (cfg *apiConfig) revokeHandler(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+func (cfg *apiConfig) getChirpByIDHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("chirp_id")
+
+	if len(id) == 0 {
+		log.Printf("Error getting chirp_id")
+		respondWithError(w, 404, "Not found")
+		return
+	}
 
+	uid, err := uuid.Parse(id)
 	if err != nil {
-		log.Printf("Error fetching refresh token from a header: %s", err)
-		respondWithError(w, 401, "Refresh token is not present")
+		log.Printf("Error parsing uuid: %s", err)
+		respondWithError(w, 500, "Something went wrong")
 		return
 	}
 
-	if err := cfg.db.UpdateRefreshToken(r.Context(), token); err != nil {
-		log.Printf("Error fetching refresh token from a database: %s", err)
-		respondWithError(w, 401, "Refresh token is right")
-		return	
+	chirpDTO, err := cfg.db.GetVisibleChirpByID(r.Context(), uid)
+	if err != nil {
+		log.Printf("Error retrieving chirp: %s", err)
+		respondWithError(w, 404, "Nor found")
+		return
 	}
 
-	respondWithJSON(w, 204, struct{}{})
-}
+	response := MapChirpDTOToChirp(chirpDTO)
 
-func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request)  {
-	type parameters struct {
-		Email string `json:"email"`
-		Password string `json:"password"`
+	if err := cfg.decorateChirps(r.Context(), viewerID(r), []*Chirp{&response}, expandAuthor(r)); err != nil {
+		log.Printf("Error loading chirp engagement: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
 	}
-	token, err := auth.GetBearerToken(r.Header)
 
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) getChirpThreadHandler(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirp_id"))
 	if err != nil {
-		log.Printf("Error fetching access token from a header: %s", err)
-		respondWithError(w, 401, "Access token is not present")
+		log.Printf("Error parsing uuid: %s", err)
+		respondWithError(w, 400, "Bad request")
 		return
 	}
 
-	user_id, err := auth.ValidateJWT(token, cfg.secret)
+	page, err := parsePageParams(r.URL.Query())
 	if err != nil {
-		log.Printf("Error token is not valid: %s", err)
-		respondWithError(w, 401, "Access token is not valid")
+		respondPageError(w, err)
 		return
 	}
 
-	params := parameters{}
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&params); err != nil {
-		log.Printf("Error decoding parameters: %s", err)
+	chirpDTO, err := cfg.db.GetVisibleChirpByID(r.Context(), chirpID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error retrieving chirp: %s", err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
 
-	hashedPassword, _ := auth.HashPassword(params.Password)
+	ancestorDTOS, err := cfg.db.GetChirpAncestors(r.Context(), chirpID)
+	if err != nil {
+		log.Printf("Error retrieving ancestors of %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
 
-	log.Printf("Email: %s Password %s", params.Email, hashedPassword)
-	userDTO, err := cfg.db.UpdateUser(r.Context(), database.UpdateUserParams{
-		ID: user_id,
-		HashedPassword: hashedPassword,
-		Email: params.Email,
+	replyDTOS, err := cfg.db.GetChirpDescendants(r.Context(), database.GetChirpDescendantsParams{
+		ChirpID: chirpID,
+		CursorCreatedAt: page.cursorCreatedAt,
+		CursorID: page.cursorID,
+		PageLimit: int32(page.limit + 1),
 	})
 	if err != nil {
-		log.Printf("Error updating user: %s", err)
+		log.Printf("Error retrieving replies to %s: %s", chirpID, err)
 		respondWithError(w, 500, "Something went wrong")
 		return
 	}
 
-	user := MapUserDTOToUser(userDTO)
+	response := Thread{
+		Chirp: MapChirpDTOToChirp(chirpDTO),
+		Ancestors: make([]Chirp, len(ancestorDTOS)),
+	}
+	for i, c := range ancestorDTOS {
+		response.Ancestors[i] = MapChirpDTOToChirp(c)
+	}
 
-	respondWithJSON(w, 200, user)
-}
+	if len(replyDTOS) > page.limit {
+		replyDTOS = replyDTOS[:page.limit]
+		last := replyDTOS[page.limit - 1]
+		response.NextCursor = setNextCursor(w, r, pagination.Cursor{CreatedAt: last.CreatedAt, ID: last.ID})
+	}
 
-func (cfg *apiConfig) deleteChirpByIDHandler(w http.ResponseWriter, r *http.Request) {
-    token, err := auth.GetBearerToken(r.Header)
-    if err != nil {
-        log.Printf("Error fetching access token: %s", err)
-        respondWithError(w, 401, "Access token is not present")
-        return
-    }
+	response.Replies = make([]ThreadReply, len(replyDTOS))
+	for i, c := range replyDTOS {
+		response.Replies[i] = MapThreadReplyDTOToThreadReply(c)
+	}
 
-    userID, err := auth.ValidateJWT(token, cfg.secret)
-    if err != nil {
-        log.Printf("Invalid token: %s", err)
-        respondWithError(w, 403, "Access token is not valid")
-        return
-    }
+	chirps := []*Chirp{&response.Chirp}
+	for i := range response.Ancestors {
+		chirps = append(chirps, &response.Ancestors[i])
+	}
+	for i := range response.Replies {
+		chirps = append(chirps, &response.Replies[i].Chirp)
+	}
+	if err := cfg.decorateChirps(r.Context(), viewerID(r), chirps, expandAuthor(r)); err != nil {
+		log.Printf("Error loading chirp engagement: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) loginHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		Password string `json:"password"`
+		EIS int `json:"expires_in_seconds,omitempty"`
+		Scope string `json:"scope,omitempty"`
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	blockedUntil, err := cfg.db.GetLoginIPBlockedUntil(r.Context(), clientIP(r, cfg.trustedProxies))
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error checking login throttle: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if blockedUntil.Valid && blockedUntil.Time.After(time.Now()) {
+		respondTooManyRequests(w, blockedUntil.Time, "Too many failed logins, try again later")
+		return
+	}
+
+	userDTO, err := cfg.db.GetUserByEmail(r.Context(), params.Email)
+	if err == sql.ErrNoRows {
+		auth.CheckPasswordHash(params.Password, dummyPasswordHash(cfg.passwordParams))
+		cfg.recordLoginFailure(r, nil, "unknown email")
+		respondWithError(w, 401, "Incorrect email or password")
+		return
+	}
+	if err != nil {
+		log.Printf("Error connecting to db: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	// a locked account answers exactly like a wrong password, since a
+	// distinct answer would tell anyone that the email has an account; the
+	// owner learns about the lock from the unlock email instead
+	flag, _ := auth.CheckPasswordHash(params.Password, userDTO.HashedPassword)
+	if accountLocked(userDTO) {
+		cfg.recordLoginFailure(r, &userDTO, "account locked")
+		respondWithError(w, 401, "Incorrect email or password")
+		return
+	}
+	if !flag {
+		cfg.recordLoginFailure(r, &userDTO, "wrong password")
+		respondWithError(w, 401, "Incorrect email or password")
+		return
+	}
+	cfg.clearLoginFailures(r.Context(), userDTO)
+	cfg.upgradePasswordHash(r.Context(), userDTO, params.Password)
+
+	scopes, err := auth.ParseScopes(params.Scope)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	// the password was right but a second factor is still owed
+	if userDTO.TotpEnabledAt.Valid {
+		challenge, err := auth.MakeMFAChallenge(userDTO.ID, scopes, cfg.keys, mfaChallengeTTL)
+		if err != nil {
+			log.Printf("Error constructing the MFA challenge: %s", err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+		respondWithJSON(w, 200, MFAChallenge{
+			MFARequired: true,
+			MFAToken: challenge,
+		})
+		return
+	}
+
+	cfg.completeLogin(w, r, userDTO, scopes, params.EIS)
+}
+
+// completeLogin issues the access and refresh tokens once every factor checked out.
+func (cfg *apiConfig) completeLogin(w http.ResponseWriter, r *http.Request, userDTO database.User, scopes []string, expiresInSeconds int) {
+	if expiresInSeconds < 1 || expiresInSeconds > 60 {
+		expiresInSeconds = 60
+	}
+
+	restored, err := cfg.restoreAccount(r, userDTO)
+	if err != nil {
+		log.Printf("Error restoring user %s: %s", userDTO.ID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if !restored {
+		respondWithError(w, 401, "Incorrect email or password")
+		return
+	}
+
+	familyID := uuid.New()
+	jwt, err := auth.MakeJWT(auth.Principal{
+		UserID: userDTO.ID,
+		Scopes: scopes,
+		Roles: []string{userDTO.Role},
+		TokenVersion: userDTO.TokenVersion,
+		SessionID: familyID,
+	}, cfg.keys, time.Second * time.Duration(expiresInSeconds))
+
+	if err != nil {
+		log.Printf("Error constructing the JWT: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	refreshToken, err := cfg.issueRefreshToken(r.Context(), cfg.db, uuid.New(), userDTO.ID, familyID, scopes, r)
+	if err != nil {
+		log.Printf("Error constructing the Refresh token: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	user := MapUserDTOToUser(userDTO)
+	user.JWTToken = jwt
+	user.RefreshToken = refreshToken
+
+	respondWithJSON(w, 200, user)
+}
+
+
+func (cfg *apiConfig) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		Token string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	token, err := auth.GetBearerToken(r.Header)
+
+	if err != nil {
+		log.Printf("Error fetching refresh token from a header: %s", err)
+		respondWithError(w, 401, "Refresh token is not present")
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	prefix, hash := auth.HashRefreshToken(token, cfg.refreshTokenKey)
+	newID := uuid.New()
+	tokenDTO, err := qtx.RotateRefreshToken(r.Context(), database.RotateRefreshTokenParams{
+		ReplacedBy: newID,
+		LookupPrefix: prefix,
+		TokenHash: hash,
+	})
+
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		cfg.detectRefreshTokenReuse(r, prefix, hash)
+		log.Printf("Error refresh token has expired or doesn't exists: %s", err)
+		respondWithError(w, 401, "Refresh token has expired or doesn't exists")
+		return
+	}
+
+	if err != nil {
+		log.Printf("Error executing query: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	newToken, err := cfg.issueRefreshToken(r.Context(), qtx, newID, tokenDTO.UserID, tokenDTO.FamilyID, strings.Fields(tokenDTO.Scope), r)
+	if err != nil {
+		log.Printf("Error constructing the Refresh token: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	userDTO, err := qtx.GetUserByID(r.Context(), tokenDTO.UserID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", tokenDTO.UserID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing refresh token rotation: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	jwt, err := auth.MakeJWT(auth.Principal{
+		UserID: tokenDTO.UserID,
+		Scopes: strings.Fields(tokenDTO.Scope),
+		Roles: []string{userDTO.Role},
+		TokenVersion: userDTO.TokenVersion,
+		SessionID: tokenDTO.FamilyID,
+	}, cfg.keys, time.Duration(1) * time.Hour)
+
+	if err != nil {
+		log.Printf("Error : %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, response {
+		Token: jwt,
+		RefreshToken: newToken,
+	})
+}
+
+func (cfg *apiConfig) revokeHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := auth.GetBearerToken(r.Header)
+
+	if err != nil {
+		log.Printf("Error fetching refresh token from a header: %s", err)
+		respondWithError(w, 401, "Refresh token is not present")
+		return
+	}
+
+	prefix, hash := auth.HashRefreshToken(token, cfg.refreshTokenKey)
+	if err := cfg.db.UpdateRefreshToken(r.Context(), database.UpdateRefreshTokenParams{
+		LookupPrefix: prefix,
+		TokenHash: hash,
+	}); err != nil {
+		log.Printf("Error fetching refresh token from a database: %s", err)
+		respondWithError(w, 401, "Refresh token is right")
+		return	
+	}
+
+	respondWithJSON(w, 204, struct{}{})
+}
+
+// userUpdate holds the account fields a request wants to change; nil means
+// leave the field as it is.
+type userUpdate struct {
+	Email *string `json:"email"`
+	Password *string `json:"password"`
+	CurrentPassword string `json:"current_password"`
+	Handle *string `json:"handle"`
+	DisplayName *string `json:"display_name"`
+	Bio *string `json:"bio"`
+}
+
+// updateUserHandler changes only the fields that are present. Changing the
+// email or password needs the current password, and a new email has to be
+// verified again. Profile fields can be changed without it.
+func (cfg *apiConfig) updateUserHandler(w http.ResponseWriter, r *http.Request)  {
+	params := userUpdate{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	cfg.applyUserUpdate(w, r, params)
+}
+
+// replaceUserHandler keeps the PUT contract of replacing both email and
+// password, which are required. It goes through the same checks as PATCH,
+// so current_password is required too.
+func (cfg *apiConfig) replaceUserHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+		Password string `json:"password"`
+		CurrentPassword string `json:"current_password"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+	if params.Email == "" {
+		respondWithError(w, 400, "Email is required")
+		return
+	}
+
+	cfg.applyUserUpdate(w, r, userUpdate{
+		Email: &params.Email,
+		Password: &params.Password,
+		CurrentPassword: params.CurrentPassword,
+	})
+}
+
+// applyUserUpdate re-authenticates, validates and saves params for the
+// caller. A new password signs out every other session.
+func (cfg *apiConfig) applyUserUpdate(w http.ResponseWriter, r *http.Request, params userUpdate) {
+	principal := requestPrincipal(r)
+	user_id := principal.UserID
+
+	userDTO, err := cfg.db.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", user_id, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	emailChanged := params.Email != nil && *params.Email != userDTO.Email
+	passwordChanged := params.Password != nil
+	if emailChanged && *params.Email == "" {
+		respondWithError(w, 400, "Email must not be empty")
+		return
+	}
+
+	profile := database.UpdateUserProfileParams{
+		ID: user_id,
+		Handle: userDTO.Handle,
+		DisplayName: userDTO.DisplayName,
+		Bio: userDTO.Bio,
+	}
+	if params.Handle != nil {
+		if !validHandle(*params.Handle) {
+			respondWithError(w, 400, "Handle must be 3 to 30 letters, digits or underscores")
+			return
+		}
+		profile.Handle = *params.Handle
+	}
+	if params.DisplayName != nil {
+		profile.DisplayName = strings.TrimSpace(*params.DisplayName)
+		if textlen.Graphemes(profile.DisplayName) > maxDisplayNameLength {
+			respondWithError(w, 400, fmt.Sprintf("Display name must be at most %d characters", maxDisplayNameLength))
+			return
+		}
+	}
+	if params.Bio != nil {
+		profile.Bio = strings.TrimSpace(*params.Bio)
+		if textlen.Graphemes(profile.Bio) > maxBioLength {
+			respondWithError(w, 400, fmt.Sprintf("Bio must be at most %d characters", maxBioLength))
+			return
+		}
+	}
+	profileChanged := profile.Handle != userDTO.Handle ||
+		profile.DisplayName != userDTO.DisplayName ||
+		profile.Bio != userDTO.Bio
+
+	if !emailChanged && !passwordChanged && !profileChanged {
+		respondWithJSON(w, 200, MapUserDTOToUser(userDTO))
+		return
+	}
+
+	if emailChanged || passwordChanged {
+		if !cfg.checkAccountLock(w, userDTO) {
+			return
+		}
+		if flag, _ := auth.CheckPasswordHash(params.CurrentPassword, userDTO.HashedPassword); !flag {
+			cfg.recordLoginFailure(r, &userDTO, "wrong current password")
+			respondWithError(w, 403, "Current password is incorrect")
+			return
+		}
+		cfg.clearLoginFailures(r.Context(), userDTO)
+	}
+
+	email := userDTO.Email
+	if emailChanged {
+		email = *params.Email
+	}
+
+	hashedPassword := ""
+	if passwordChanged {
+		if !cfg.checkPassword(w, r, *params.Password, email) {
+			return
+		}
+		hashedPassword, err = auth.HashPasswordWithParams(*params.Password, cfg.passwordParams)
+		if err != nil {
+			log.Printf("Error hashing password: %s", err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	if emailChanged {
+		_, err := qtx.UpdateUserEmail(r.Context(), database.UpdateUserEmailParams{
+			ID: user_id,
+			Email: email,
+		})
+		if respondUserConflict(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error updating email of user %s: %s", user_id, err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+
+		if err := qtx.DeleteEmailVerificationTokens(r.Context(), user_id); err != nil {
+			log.Printf("Error deleting verification tokens for user %s: %s", user_id, err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+	}
+
+	if profileChanged {
+		_, err := qtx.UpdateUserProfile(r.Context(), profile)
+		if respondUserConflict(w, err) {
+			return
+		}
+		if err != nil {
+			log.Printf("Error updating profile of user %s: %s", user_id, err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+	}
+
+	if passwordChanged {
+		err := qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			ID: user_id,
+			HashedPassword: hashedPassword,
+		})
+		if err != nil {
+			log.Printf("Error updating password for user %s: %s", user_id, err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+
+		if err := qtx.DeletePasswordResetTokens(r.Context(), user_id); err != nil {
+			log.Printf("Error deleting password reset tokens for user %s: %s", user_id, err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+
+		// keep the caller's session, sign out everywhere else
+		_, err = qtx.RevokeOtherSessions(r.Context(), database.RevokeOtherSessionsParams{
+			UserID: user_id,
+			FamilyID: principal.SessionID,
+		})
+		if err != nil {
+			log.Printf("Error revoking other sessions of user %s: %s", user_id, err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+
+		if _, err := qtx.BumpUserTokenVersion(r.Context(), user_id); err != nil {
+			log.Printf("Error bumping token version for user %s: %s", user_id, err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+	}
+
+	userDTO, err = qtx.GetUserByID(r.Context(), user_id)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", user_id, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing user update: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if emailChanged {
+		if err := cfg.sendEmailVerification(r.Context(), userDTO); err != nil {
+			log.Printf("Error sending verification email to user %s: %s", user_id, err)
+		}
+	}
+
+	user := MapUserDTOToUser(userDTO)
+
+	// the version bump invalidated the caller's access token along with
+	// everyone else's, so hand out a replacement
+	if passwordChanged {
+		jwt, err := auth.MakeJWT(auth.Principal{
+			UserID: user_id,
+			Scopes: principal.Scopes,
+			Roles: []string{userDTO.Role},
+			TokenVersion: userDTO.TokenVersion,
+			SessionID: principal.SessionID,
+		}, cfg.keys, time.Hour)
+		if err != nil {
+			log.Printf("Error constructing the JWT: %s", err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+		user.JWTToken = jwt
+	}
+
+	respondWithJSON(w, 200, user)
+}
+
+func (cfg *apiConfig) deleteChirpByIDHandler(w http.ResponseWriter, r *http.Request) {
+    userID := requestPrincipal(r).UserID
 
     chirpIDStr := r.PathValue("chirpID")
     if chirpIDStr == "" {
@@ -406,25 +1352,219 @@ func (cfg *apiConfig) deleteChirpByIDHandler(w http.ResponseWriter, r *http.Requ
         return
     }
 
-    if chirpDTO.UserID != userID {
+    if chirpDTO.DeletedAt.Valid {
+        respondWithError(w, 404, "Not found")
+        return
+    }
+
+    if chirpDTO.UserID.UUID != userID && !requestPrincipal(r).Can(auth.PermDeleteAnyChirp) {
         // user authenticated, but doesn't own this chirp — forbidden
         log.Printf("User %s not authorized to delete chirp %s", userID, chirpIDStr)
         respondWithError(w, 403, "Not authorized")
         return
     }
 
-    if err := cfg.db.DeleteChirpByID(r.Context(), database.DeleteChirpByIDParams{
+    // moderators delete on behalf of the author
+    deleted, err := cfg.db.DeleteChirpByID(r.Context(), database.DeleteChirpByIDParams{
         ID:     chirpUUID,
-        UserID: userID,
-    }); err != nil {
+        UserID: chirpDTO.UserID.UUID,
+    })
+    if err != nil {
         log.Printf("Error deleting chirp %s: %s", chirpIDStr, err)
         respondWithError(w, 500, "Something went wrong")
         return
     }
 
+    // the chirp has replies, quotes or rechirps — keep a tombstone so they are not orphaned
+    if deleted == 0 {
+        if err := cfg.tombstoneChirp(r.Context(), chirpUUID, chirpDTO.UserID.UUID); err != nil {
+            log.Printf("Error tombstoning chirp %s: %s", chirpIDStr, err)
+            respondWithError(w, 500, "Something went wrong")
+            return
+        }
+    }
+
     w.WriteHeader(http.StatusNoContent) // 204, no body
 }
 
+// tombstoneChirp blanks a chirp and drops everything derived from its body, including old revisions.
+func (cfg *apiConfig) tombstoneChirp(ctx context.Context, chirpID, userID uuid.UUID) error {
+	tx, err := cfg.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	if err := qtx.TombstoneChirp(ctx, database.TombstoneChirpParams{
+		ID: chirpID,
+		UserID: userID,
+	}); err != nil {
+		return err
+	}
+	if err := qtx.DeleteChirpRevisions(ctx, chirpID); err != nil {
+		return err
+	}
+	if err := clearChirpEntities(ctx, qtx, chirpID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (cfg *apiConfig) editChirpHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	chirpID, err := uuid.Parse(r.PathValue("chirp_id"))
+	if err != nil {
+		log.Printf("Invalid chirp_id: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	userDTO, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", userID, err)
+		respondWithError(w, 401, "Access token is not valid")
+		return
+	}
+
+	if !userDTO.IsChirpyRed.Bool {
+		respondWithError(w, 403, "Editing chirps requires Chirpy Red")
+		return
+	}
+
+	chirpDTO, err := cfg.db.GetChirpByID(r.Context(), chirpID)
+	if err == sql.ErrNoRows || (err == nil && chirpDTO.DeletedAt.Valid) {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error retrieving chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if chirpDTO.UserID.UUID != userID {
+		log.Printf("User %s not authorized to edit chirp %s", userID, chirpID)
+		respondWithError(w, 403, "Not authorized")
+		return
+	}
+
+	if chirpDTO.RechirpOf.Valid {
+		respondWithError(w, 400, "Rechirps cannot be edited")
+		return
+	}
+
+	if time.Since(chirpDTO.CreatedAt) > cfg.editWindow {
+		respondWithError(w, 403, fmt.Sprintf("Chirps can only be edited within %s of posting", cfg.editWindow))
+		return
+	}
+
+	if !checkChirpLength(w, params.Body, userDTO.IsChirpyRed.Bool) {
+		return
+	}
+
+	// edits are not queued for review — anything that would be held is refused
+	moderated := cfg.moderator.Check(params.Body)
+	if moderated.Action == moderation.ActionReject || moderated.Action == moderation.ActionHold {
+		respondWithError(w, 422, "Chirp contains prohibited content")
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// the previous body is copied into chirp_revisions by the same statement
+	chirpDTO, err = qtx.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+		ID: chirpID,
+		Body: moderated.Body,
+	})
+	if err != nil {
+		log.Printf("Error updating chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := clearChirpEntities(r.Context(), qtx, chirpID); err != nil {
+		log.Printf("Error clearing entities of chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := saveChirpEntities(r.Context(), qtx, chirpDTO); err != nil {
+		log.Printf("Error saving entities of chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	response := MapChirpDTOToChirp(chirpDTO)
+	if err := cfg.decorateChirps(r.Context(), uuid.NullUUID{UUID: userID, Valid: true}, []*Chirp{&response}, expandAuthor(r)); err != nil {
+		log.Printf("Error loading chirp engagement: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) getChirpRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := uuid.Parse(r.PathValue("chirp_id"))
+	if err != nil {
+		log.Printf("Invalid chirp_id: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	chirpDTO, err := cfg.db.GetVisibleChirpByID(r.Context(), chirpID)
+	if err == sql.ErrNoRows || (err == nil && chirpDTO.DeletedAt.Valid) {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error retrieving chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	revisionDTOS, err := cfg.db.GetChirpRevisions(r.Context(), chirpID)
+	if err != nil {
+		log.Printf("Error retrieving revisions of chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	response := make([]ChirpRevision, len(revisionDTOS))
+	for i, rev := range revisionDTOS {
+		response[i] = MapChirpRevisionDTOToChirpRevision(rev)
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
 func (cfg *apiConfig) polkaWebhookHandler(w http.ResponseWriter, r *http.Request) {
 	type data struct {
 		UserID uuid.UUID `json:"user_id"`
@@ -461,4 +1601,2294 @@ func (cfg *apiConfig) polkaWebhookHandler(w http.ResponseWriter, r *http.Request
 	}
 
 	respondWithJSON(w, 204, struct{}{})
-}
\ No newline at end of file
+}
+
+func (cfg *apiConfig) followHandler(w http.ResponseWriter, r *http.Request) {
+	followerID := requestPrincipal(r).UserID
+
+	followeeID, err := uuid.Parse(r.PathValue("user_id"))
+	if err != nil {
+		log.Printf("Invalid user_id: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	if followeeID == followerID {
+		respondWithError(w, 400, "You cannot follow yourself")
+		return
+	}
+
+	if _, err := cfg.db.GetUserByID(r.Context(), followeeID); err == sql.ErrNoRows {
+		respondWithError(w, 404, "User not found")
+		return
+	} else if err != nil {
+		log.Printf("Error retrieving user %s: %s", followeeID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := cfg.db.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+	}); err != nil {
+		log.Printf("Error following user %s: %s", followeeID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) unfollowHandler(w http.ResponseWriter, r *http.Request) {
+	followerID := requestPrincipal(r).UserID
+
+	followeeID, err := uuid.Parse(r.PathValue("user_id"))
+	if err != nil {
+		log.Printf("Invalid user_id: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	if err := cfg.db.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: followerID,
+		FolloweeID: followeeID,
+	}); err != nil {
+		log.Printf("Error unfollowing user %s: %s", followeeID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) getFollowersHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollows(w, r, cfg.db.ListFollowers, func(f database.Follow) uuid.UUID { return f.FollowerID })
+}
+
+func (cfg *apiConfig) getFollowingHandler(w http.ResponseWriter, r *http.Request) {
+	listFollowing := func(ctx context.Context, arg database.ListFollowersParams) ([]database.Follow, error) {
+		return cfg.db.ListFollowing(ctx, database.ListFollowingParams(arg))
+	}
+	cfg.listFollows(w, r, listFollowing, func(f database.Follow) uuid.UUID { return f.FolloweeID })
+}
+
+// listFollows serves one side of the follow graph; other picks the user on the far end of each edge.
+func (cfg *apiConfig) listFollows(
+	w http.ResponseWriter,
+	r *http.Request,
+	list func(context.Context, database.ListFollowersParams) ([]database.Follow, error),
+	other func(database.Follow) uuid.UUID,
+) {
+	userID, err := uuid.Parse(r.PathValue("user_id"))
+	if err != nil {
+		log.Printf("Invalid user_id: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondPageError(w, err)
+		return
+	}
+
+	followDTOS, err := list(r.Context(), database.ListFollowersParams{
+		UserID: userID,
+		CursorCreatedAt: page.cursorCreatedAt,
+		CursorID: page.cursorID,
+		PageLimit: int32(page.limit + 1),
+	})
+	if err != nil {
+		log.Printf("Error retrieving follows: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	response := FollowPage{}
+	if len(followDTOS) > page.limit {
+		followDTOS = followDTOS[:page.limit]
+		last := followDTOS[page.limit - 1]
+		response.NextCursor = setNextCursor(w, r, pagination.Cursor{CreatedAt: last.CreatedAt, ID: other(last)})
+	}
+
+	response.Users = make([]Follow, len(followDTOS))
+	for i, f := range followDTOS {
+		response.Users[i] = Follow{
+			UserID: other(f),
+			CreatedAt: f.CreatedAt,
+		}
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) timelineHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondPageError(w, err)
+		return
+	}
+
+	chirpsDTOS, err := cfg.db.GetTimeline(r.Context(), database.GetTimelineParams{
+		UserID: userID,
+		CursorCreatedAt: page.cursorCreatedAt,
+		CursorID: page.cursorID,
+		PageLimit: int32(page.limit + 1),
+	})
+	if err != nil {
+		log.Printf("Error retrieving timeline for %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	response, err := cfg.chirpPage(w, r, chirpsDTOS, page.limit)
+	if err != nil {
+		log.Printf("Error loading chirp engagement: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) getHashtagChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	tag := entities.NormalizeHashtag(r.PathValue("tag"))
+	if tag == "" {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+
+	page, err := parsePageParams(r.URL.Query())
+	if err != nil {
+		respondPageError(w, err)
+		return
+	}
+
+	chirpsDTOS, err := cfg.db.ListChirpsByHashtag(r.Context(), database.ListChirpsByHashtagParams{
+		Tag: tag,
+		CursorCreatedAt: page.cursorCreatedAt,
+		CursorID: page.cursorID,
+		PageLimit: int32(page.limit + 1),
+	})
+	if err != nil {
+		log.Printf("Error retrieving chirps for #%s: %s", tag, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	response, err := cfg.chirpPage(w, r, chirpsDTOS, page.limit)
+	if err != nil {
+		log.Printf("Error loading chirp engagement: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) trendingHashtagsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	window := 24 * time.Hour
+	if s := query.Get("window"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 || d > 7 * 24 * time.Hour {
+			respondWithError(w, 400, "window must be a duration between 0 and 168h")
+			return
+		}
+		window = d
+	}
+
+	limit := 10
+	if s := query.Get("limit"); s != "" {
+		l, err := pagination.ParseLimit(s)
+		if err != nil {
+			respondWithError(w, 400, err.Error())
+			return
+		}
+		limit = l
+	}
+
+	trendingDTOS, err := cfg.db.GetTrendingHashtags(r.Context(), database.GetTrendingHashtagsParams{
+		Since: time.Now().Add(-window),
+		RowLimit: int32(limit),
+	})
+	if err != nil {
+		log.Printf("Error retrieving trending hashtags: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	response := make([]TrendingHashtag, len(trendingDTOS))
+	for i, t := range trendingDTOS {
+		response[i] = TrendingHashtag{
+			Tag: t.Tag,
+			Uses: t.Uses,
+		}
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) likeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.engage(w, r, func(ctx context.Context, q *database.Queries, chirpID, userID uuid.UUID) error {
+		liked, err := q.LikeChirp(ctx, database.LikeChirpParams{
+			ChirpID: chirpID,
+			UserID: userID,
+		})
+		if err != nil || liked == 0 {
+			return err
+		}
+		return q.IncrementLikeCount(ctx, chirpID)
+	})
+}
+
+func (cfg *apiConfig) unlikeChirpHandler(w http.ResponseWriter, r *http.Request) {
+	cfg.engage(w, r, func(ctx context.Context, q *database.Queries, chirpID, userID uuid.UUID) error {
+		unliked, err := q.UnlikeChirp(ctx, database.UnlikeChirpParams{
+			ChirpID: chirpID,
+			UserID: userID,
+		})
+		if err != nil || unliked == 0 {
+			return err
+		}
+		return q.DecrementLikeCount(ctx, chirpID)
+	})
+}
+
+func (cfg *apiConfig) addReactionHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Emoji string `json:"emoji"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	if !validEmoji(params.Emoji) {
+		respondWithError(w, 400, "Reaction must be a single emoji")
+		return
+	}
+
+	cfg.engage(w, r, func(ctx context.Context, q *database.Queries, chirpID, userID uuid.UUID) error {
+		added, err := q.AddReaction(ctx, database.AddReactionParams{
+			ChirpID: chirpID,
+			UserID: userID,
+			Emoji: params.Emoji,
+		})
+		if err != nil || added == 0 {
+			return err
+		}
+		return q.IncrementReactionCount(ctx, database.IncrementReactionCountParams{
+			ChirpID: chirpID,
+			Emoji: params.Emoji,
+		})
+	})
+}
+
+func (cfg *apiConfig) removeReactionHandler(w http.ResponseWriter, r *http.Request) {
+	emoji := r.PathValue("emoji")
+
+	cfg.engage(w, r, func(ctx context.Context, q *database.Queries, chirpID, userID uuid.UUID) error {
+		removed, err := q.RemoveReaction(ctx, database.RemoveReactionParams{
+			ChirpID: chirpID,
+			UserID: userID,
+			Emoji: emoji,
+		})
+		if err != nil || removed == 0 {
+			return err
+		}
+		if err := q.DecrementReactionCount(ctx, database.DecrementReactionCountParams{
+			ChirpID: chirpID,
+			Emoji: emoji,
+		}); err != nil {
+			return err
+		}
+		return q.DeleteEmptyReactionCounts(ctx, chirpID)
+	})
+}
+
+// engage runs a like/reaction change for the caller in one transaction, so the
+// per-user row and the aggregated count can never drift apart, then responds with the updated chirp.
+func (cfg *apiConfig) engage(
+	w http.ResponseWriter,
+	r *http.Request,
+	change func(ctx context.Context, q *database.Queries, chirpID, userID uuid.UUID) error,
+) {
+	userID := requestPrincipal(r).UserID
+
+	chirpID, err := uuid.Parse(r.PathValue("chirp_id"))
+	if err != nil {
+		log.Printf("Invalid chirp_id: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	chirpDTO, err := cfg.db.GetVisibleChirpByID(r.Context(), chirpID)
+	if err == sql.ErrNoRows || (err == nil && chirpDTO.DeletedAt.Valid) {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error retrieving chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+
+	if err := change(r.Context(), cfg.db.WithTx(tx), chirpID, userID); err != nil {
+		log.Printf("Error updating engagement on chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing engagement on chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	chirpDTO, err = cfg.db.GetChirpByID(r.Context(), chirpID)
+	if err != nil {
+		log.Printf("Error retrieving chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	response := MapChirpDTOToChirp(chirpDTO)
+	if err := cfg.decorateChirps(r.Context(), uuid.NullUUID{UUID: userID, Valid: true}, []*Chirp{&response}, expandAuthor(r)); err != nil {
+		log.Printf("Error loading chirp engagement: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) rechirpHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	chirpID, err := uuid.Parse(r.PathValue("chirp_id"))
+	if err != nil {
+		log.Printf("Invalid chirp_id: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	originalDTO, err := cfg.getOriginalChirp(r.Context(), chirpID)
+	if err == sql.ErrNoRows || (err == nil && originalDTO.DeletedAt.Valid) {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error retrieving chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	status := 201
+	rechirpDTO, err := cfg.db.CreateRechirp(r.Context(), database.CreateRechirpParams{
+		UserID: userID,
+		RechirpOf: originalDTO.ID,
+	})
+	if err == sql.ErrNoRows {
+		// already rechirped — return the existing one
+		status = 200
+		rechirpDTO, err = cfg.db.GetRechirp(r.Context(), database.GetRechirpParams{
+			UserID: userID,
+			RechirpOf: originalDTO.ID,
+		})
+	}
+	if err != nil {
+		log.Printf("Error rechirping %s: %s", originalDTO.ID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	response := MapChirpDTOToChirp(rechirpDTO)
+	if err := cfg.decorateChirps(r.Context(), uuid.NullUUID{UUID: userID, Valid: true}, []*Chirp{&response}, expandAuthor(r)); err != nil {
+		log.Printf("Error loading chirp engagement: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, status, response)
+}
+
+func (cfg *apiConfig) undoRechirpHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	chirpID, err := uuid.Parse(r.PathValue("chirp_id"))
+	if err != nil {
+		log.Printf("Invalid chirp_id: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	// the rechirp itself may be passed instead of the original; a deleted
+	// original can still be un-rechirped
+	originalDTO, err := cfg.getOriginalChirp(r.Context(), chirpID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error retrieving chirp %s: %s", chirpID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	deleted, err := cfg.db.DeleteRechirp(r.Context(), database.DeleteRechirpParams{
+		UserID: userID,
+		RechirpOf: originalDTO.ID,
+	})
+	if err != nil {
+		log.Printf("Error undoing rechirp of %s: %s", originalDTO.ID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// moderationRules is the database source for the moderation filter.
+func (cfg *apiConfig) moderationRules(ctx context.Context) ([]moderation.Rule, error) {
+	ruleDTOS, err := cfg.db.ListModerationRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]moderation.Rule, len(ruleDTOS))
+	for i, rule := range ruleDTOS {
+		rules[i] = moderation.Rule{
+			Pattern: rule.Pattern,
+			Action: moderation.Action(rule.Action),
+		}
+	}
+	return rules, nil
+}
+
+func (cfg *apiConfig) listModerationRulesHandler(w http.ResponseWriter, r *http.Request) {
+	ruleDTOS, err := cfg.db.ListModerationRules(r.Context())
+	if err != nil {
+		log.Printf("Error retrieving moderation rules: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	response := make([]ModerationRule, len(ruleDTOS))
+	for i, rule := range ruleDTOS {
+		response[i] = MapModerationRuleDTOToModerationRule(rule)
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) upsertModerationRuleHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Pattern string `json:"pattern"`
+		Action string `json:"action"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	action, err := moderation.ParseAction(params.Action)
+	if err != nil {
+		respondWithError(w, 400, err.Error())
+		return
+	}
+
+	pattern := moderation.Normalize(params.Pattern)
+	if pattern == "" {
+		respondWithError(w, 400, "pattern must contain letters or digits")
+		return
+	}
+
+	ruleDTO, err := cfg.db.UpsertModerationRule(r.Context(), database.UpsertModerationRuleParams{
+		Pattern: pattern,
+		Action: string(action),
+	})
+	if err != nil {
+		log.Printf("Error saving moderation rule: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := cfg.moderator.Reload(r.Context()); err != nil {
+		log.Printf("Error reloading moderation rules: %s", err)
+	}
+
+	respondWithJSON(w, 200, MapModerationRuleDTOToModerationRule(ruleDTO))
+}
+
+func (cfg *apiConfig) deleteModerationRuleHandler(w http.ResponseWriter, r *http.Request) {
+	ruleID, err := uuid.Parse(r.PathValue("rule_id"))
+	if err != nil {
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	deleted, err := cfg.db.DeleteModerationRule(r.Context(), ruleID)
+	if err != nil {
+		log.Printf("Error deleting moderation rule %s: %s", ruleID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+
+	if err := cfg.moderator.Reload(r.Context()); err != nil {
+		log.Printf("Error reloading moderation rules: %s", err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) listHeldChirpsHandler(w http.ResponseWriter, r *http.Request) {
+	heldDTOS, err := cfg.db.ListHeldChirps(r.Context())
+	if err != nil {
+		log.Printf("Error retrieving held chirps: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	response := make([]HeldChirp, len(heldDTOS))
+	for i, held := range heldDTOS {
+		response[i] = MapHeldChirpDTOToHeldChirp(held)
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) approveHeldChirpHandler(w http.ResponseWriter, r *http.Request) {
+	heldID, err := uuid.Parse(r.PathValue("held_id"))
+	if err != nil {
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	// taking the row locks it, so concurrent approvals publish it only once
+	heldDTO, err := qtx.TakeHeldChirp(r.Context(), heldID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error retrieving held chirp %s: %s", heldID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	// the chirp it replies to or quotes may have gone away while it was held;
+	// the row stays in the queue so it can still be rejected
+	if heldDTO.InReplyTo.Valid {
+		parentDTO, err := originalChirp(r.Context(), qtx, heldDTO.InReplyTo.UUID)
+		if err == sql.ErrNoRows || (err == nil && parentDTO.DeletedAt.Valid) {
+			respondWithError(w, 409, "Parent chirp is no longer available")
+			return
+		}
+		if err != nil {
+			log.Printf("Error retrieving parent chirp %s: %s", heldDTO.InReplyTo.UUID, err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+	}
+	if heldDTO.QuoteOf.Valid {
+		quotedDTO, err := originalChirp(r.Context(), qtx, heldDTO.QuoteOf.UUID)
+		if err == sql.ErrNoRows || (err == nil && quotedDTO.DeletedAt.Valid) {
+			respondWithError(w, 409, "Quoted chirp is no longer available")
+			return
+		}
+		if err != nil {
+			log.Printf("Error retrieving quoted chirp %s: %s", heldDTO.QuoteOf.UUID, err)
+			respondWithError(w, 500, "Something went wrong")
+			return
+		}
+	}
+
+	// a moderator approved the content as written, so it is published unmasked
+	chirpDTO, err := insertChirp(r.Context(), qtx, database.CreateChirpParams{
+		Body: heldDTO.Body,
+		UserID: heldDTO.UserID,
+		InReplyTo: heldDTO.InReplyTo,
+		QuoteOf: heldDTO.QuoteOf,
+	})
+	if err != nil {
+		log.Printf("Error publishing held chirp %s: %s", heldID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing approval of held chirp %s: %s", heldID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 201, MapChirpDTOToChirp(chirpDTO))
+}
+
+func (cfg *apiConfig) rejectHeldChirpHandler(w http.ResponseWriter, r *http.Request) {
+	heldID, err := uuid.Parse(r.PathValue("held_id"))
+	if err != nil {
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	deleted, err := cfg.db.DeleteHeldChirp(r.Context(), heldID)
+	if err != nil {
+		log.Printf("Error rejecting held chirp %s: %s", heldID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if deleted == 0 {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// refreshTokenTTL is how long a refresh token can be exchanged; every
+// exchange starts a new one.
+const refreshTokenTTL = 60 * 24 * time.Hour
+
+// issueRefreshToken stores a fresh refresh token for userID in familyID and
+// returns the raw token; only its prefix and keyed hash are kept. Login starts
+// a new family, rotation keeps the family of the token it replaces, so a
+// family is one signed-in device and r supplies its user agent and IP. The
+// scopes are handed on to every access token the refresh token is exchanged for.
+func (cfg *apiConfig) issueRefreshToken(ctx context.Context, q *database.Queries, id, userID, familyID uuid.UUID, scopes []string, r *http.Request) (string, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	prefix, hash := auth.HashRefreshToken(refreshToken, cfg.refreshTokenKey)
+	if _, err := q.CreateRefreshToken(ctx, database.CreateRefreshTokenParams{
+		ID: id,
+		LookupPrefix: prefix,
+		TokenHash: hash,
+		UserID: userID,
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+		RevokedAt: sql.NullTime{},
+		FamilyID: familyID,
+		UserAgent: r.UserAgent(),
+		IpAddress: clientIP(r, cfg.trustedProxies),
+		Scope: auth.FormatScopes(scopes),
+	}); err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+// detectRefreshTokenReuse revokes the whole family when a token that was
+// already rotated is presented again: either the client or an attacker holds
+// a stolen copy, and we can't tell which.
+func (cfg *apiConfig) detectRefreshTokenReuse(r *http.Request, prefix, hash string) {
+	ctx := r.Context()
+	tokenDTO, err := cfg.db.GetAnyRefreshToken(ctx, database.GetAnyRefreshTokenParams{
+		LookupPrefix: prefix,
+		TokenHash: hash,
+	})
+	if err != nil || !tokenDTO.ReplacedBy.Valid {
+		return
+	}
+
+	revoked, err := cfg.db.RevokeRefreshTokenFamily(ctx, tokenDTO.FamilyID)
+	if err != nil {
+		log.Printf("Error revoking refresh token family %s: %s", tokenDTO.FamilyID, err)
+		return
+	}
+	cfg.recordSecurityEvent(r, uuid.NullUUID{UUID: tokenDTO.UserID, Valid: true}, eventRefreshTokenReuse,
+		fmt.Sprintf("family %s revoked (%d active tokens)", tokenDTO.FamilyID, revoked))
+}
+
+// tokenVersion checks access tokens against the user's current token version.
+func (cfg *apiConfig) tokenVersion(ctx context.Context) auth.TokenVersionFunc {
+	return func(userID uuid.UUID) (int32, error) {
+		return cfg.db.GetUserTokenVersion(ctx, userID)
+	}
+}
+
+func (cfg *apiConfig) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	sessionDTOS, err := cfg.db.ListSessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving sessions for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	response := make([]Session, len(sessionDTOS))
+	for i, session := range sessionDTOS {
+		response[i] = MapSessionDTOToSession(session)
+	}
+
+	respondWithJSON(w, 200, response)
+}
+
+func (cfg *apiConfig) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	sessionID, err := uuid.Parse(r.PathValue("session_id"))
+	if err != nil {
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	revoked, err := cfg.db.RevokeSession(r.Context(), database.RevokeSessionParams{
+		FamilyID: sessionID,
+		UserID: userID,
+	})
+	if err != nil {
+		log.Printf("Error revoking session %s: %s", sessionID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if revoked == 0 {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logoutAllHandler revokes every refresh token of the caller and bumps their
+// token version, so access tokens that are still unexpired stop working too.
+func (cfg *apiConfig) logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	if _, err := qtx.RevokeUserRefreshTokens(r.Context(), userID); err != nil {
+		log.Printf("Error revoking refresh tokens for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if _, err := qtx.BumpUserTokenVersion(r.Context(), userID); err != nil {
+		log.Printf("Error bumping token version for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing logout: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// jwksHandler publishes the public keys access tokens are verified with.
+func (cfg *apiConfig) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJSON(w, 200, cfg.keys.JWKS())
+}
+
+func (cfg *apiConfig) updateUserRoleHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	userID, err := uuid.Parse(r.PathValue("user_id"))
+	if err != nil {
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	if !auth.ValidRole(params.Role) {
+		respondWithError(w, 400, fmt.Sprintf("unknown role %q", params.Role))
+		return
+	}
+
+	if userID == requestPrincipal(r).UserID && params.Role != auth.RoleAdmin {
+		respondWithError(w, 400, "Admins cannot demote themselves")
+		return
+	}
+
+	// the role lives in access tokens, so changing it also bumps the token version
+	userDTO, err := cfg.db.UpdateUserRole(r.Context(), database.UpdateUserRoleParams{
+		ID: userID,
+		Role: params.Role,
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating role of user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	log.Printf("User %s set role of user %s to %s", requestPrincipal(r).UserID, userID, params.Role)
+	respondWithJSON(w, 200, MapUserDTOToUser(userDTO))
+}
+
+// mfaChallengeTTL is how long a user has to type their code after the password.
+const mfaChallengeTTL = 5 * time.Minute
+
+const recoveryCodeCount = 10
+
+// verifySecondFactor accepts either a current TOTP code or an unused recovery
+// code. Both are single use: a TOTP step can't be replayed and a recovery
+// code is burnt.
+func (cfg *apiConfig) verifySecondFactor(ctx context.Context, userDTO database.User, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		used, err := cfg.db.UseRecoveryCode(ctx, database.UseRecoveryCodeParams{
+			UserID: userDTO.ID,
+			CodeHash: auth.HashRecoveryCode(recoveryCode),
+		})
+		if err != nil {
+			return false, err
+		}
+		if used == 1 {
+			log.Printf("Security event: recovery code used by user %s", userDTO.ID)
+		}
+		return used == 1, nil
+	}
+
+	if !userDTO.TotpSecret.Valid {
+		return false, nil
+	}
+	secret, err := totp.Open(cfg.mfaKey, userDTO.TotpSecret.String)
+	if err != nil {
+		return false, err
+	}
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	fresh, err := cfg.db.UseTOTPStep(ctx, database.UseTOTPStepParams{
+		Step: step,
+		ID: userDTO.ID,
+	})
+	if err != nil {
+		return false, err
+	}
+	return fresh == 1, nil
+}
+
+// replaceRecoveryCodes throws away any previous recovery codes and returns a
+// fresh set. Only their hashes are stored, so this is the one time they are seen.
+func replaceRecoveryCodes(ctx context.Context, q *database.Queries, userID uuid.UUID) ([]string, error) {
+	if err := q.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return nil, err
+	}
+	codes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if err := q.CreateRecoveryCode(ctx, database.CreateRecoveryCodeParams{
+			UserID: userID,
+			CodeHash: auth.HashRecoveryCode(code),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func (cfg *apiConfig) loginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		MFAToken string `json:"mfa_token"`
+		Code string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		EIS int `json:"expires_in_seconds,omitempty"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	challenge, err := auth.ValidateMFAChallenge(params.MFAToken, cfg.keys)
+	if err != nil {
+		log.Printf("MFA token not valid: %s", err)
+		respondWithError(w, 401, "MFA token is not valid")
+		return
+	}
+
+	userDTO, err := cfg.db.GetUserByID(r.Context(), challenge.UserID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", challenge.UserID, err)
+		respondWithError(w, 401, "MFA token is not valid")
+		return
+	}
+	if !cfg.checkAccountLock(w, userDTO) {
+		return
+	}
+
+	ok, err := cfg.verifySecondFactor(r.Context(), userDTO, params.Code, params.RecoveryCode)
+	if err != nil {
+		log.Printf("Error verifying second factor for user %s: %s", userDTO.ID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if !ok {
+		cfg.recordLoginFailure(r, &userDTO, "wrong second factor")
+		respondWithError(w, 401, "Incorrect code")
+		return
+	}
+	cfg.clearLoginFailures(r.Context(), userDTO)
+
+	cfg.completeLogin(w, r, userDTO, challenge.Scopes, params.EIS)
+}
+
+func (cfg *apiConfig) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	userDTO, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if userDTO.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Printf("Error generating TOTP secret: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	sealed, err := totp.Seal(cfg.mfaKey, secret)
+	if err != nil {
+		log.Printf("Error sealing TOTP secret: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := cfg.db.SetTOTPSecret(r.Context(), database.SetTOTPSecretParams{
+		ID: userID,
+		TotpSecret: sql.NullString{String: sealed, Valid: true},
+	}); err != nil {
+		log.Printf("Error saving TOTP secret for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, TOTPEnrollment{
+		Secret: secret,
+		OTPAuthURI: totp.URI("Chirpy", userDTO.Email, secret),
+	})
+}
+
+func (cfg *apiConfig) confirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	userDTO, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if userDTO.TotpEnabledAt.Valid {
+		respondWithError(w, 409, "Two-factor authentication is already enabled")
+		return
+	}
+	if !userDTO.TotpSecret.Valid {
+		respondWithError(w, 400, "Start enrollment first")
+		return
+	}
+
+	secret, err := totp.Open(cfg.mfaKey, userDTO.TotpSecret.String)
+	if err != nil {
+		log.Printf("Error opening TOTP secret for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	step, ok := totp.Validate(secret, params.Code, time.Now())
+	if !ok {
+		respondWithError(w, 400, "Incorrect code")
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	if err := qtx.EnableTOTP(r.Context(), database.EnableTOTPParams{
+		ID: userID,
+		TotpLastStep: sql.NullInt64{Int64: step, Valid: true},
+	}); err != nil {
+		log.Printf("Error enabling TOTP for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	codes, err := replaceRecoveryCodes(r.Context(), qtx, userID)
+	if err != nil {
+		log.Printf("Error creating recovery codes for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing TOTP enrollment: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, RecoveryCodes{Codes: codes})
+}
+
+// requireSecondFactor decodes a {code, recovery_code} body and checks it
+// against the caller's enabled second factor.
+func (cfg *apiConfig) requireSecondFactor(w http.ResponseWriter, r *http.Request) (database.User, bool) {
+	type parameters struct {
+		Code string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return database.User{}, false
+	}
+
+	userDTO, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return database.User{}, false
+	}
+
+	if !userDTO.TotpEnabledAt.Valid {
+		respondWithError(w, 400, "Two-factor authentication is not enabled")
+		return database.User{}, false
+	}
+
+	ok, err := cfg.verifySecondFactor(r.Context(), userDTO, params.Code, params.RecoveryCode)
+	if err != nil {
+		log.Printf("Error verifying second factor for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return database.User{}, false
+	}
+	if !ok {
+		respondWithError(w, 403, "Incorrect code")
+		return database.User{}, false
+	}
+
+	return userDTO, true
+}
+
+func (cfg *apiConfig) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userDTO, ok := cfg.requireSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	if err := qtx.DisableTOTP(r.Context(), userDTO.ID); err != nil {
+		log.Printf("Error disabling TOTP for user %s: %s", userDTO.ID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := qtx.DeleteRecoveryCodes(r.Context(), userDTO.ID); err != nil {
+		log.Printf("Error deleting recovery codes for user %s: %s", userDTO.ID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing TOTP removal: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	userDTO, ok := cfg.requireSecondFactor(w, r)
+	if !ok {
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(r.Context(), cfg.db.WithTx(tx), userDTO.ID)
+	if err != nil {
+		log.Printf("Error creating recovery codes for user %s: %s", userDTO.ID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing recovery codes: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, RecoveryCodes{Codes: codes})
+}
+
+// deliver sends msg in the background, so that callers answer in the same
+// time whether or not an email goes out.
+func (cfg *apiConfig) deliver(msg mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := cfg.mailer.Send(ctx, msg); err != nil {
+			log.Printf("Error sending %q email: %s", msg.Subject, err)
+		}
+	}()
+}
+
+const passwordResetTTL = time.Hour
+
+// passwordResetLimit caps how many reset emails one account can trigger per
+// passwordResetTTL, so the endpoint can't be used to flood an inbox.
+const passwordResetLimit = 3
+
+func (cfg *apiConfig) requestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+	if params.Email == "" {
+		respondWithError(w, 400, "Email is required")
+		return
+	}
+
+	// The response is the same whether or not the account exists. Looking the
+	// account up only happens afterwards, so the timing can't tell either.
+	go cfg.sendPasswordReset(params.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// sendPasswordReset stores a reset token for the account with email, if there
+// is one and it hasn't hit passwordResetLimit, and mails the link.
+func (cfg *apiConfig) sendPasswordReset(email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	userDTO, err := cfg.db.GetUserByEmail(ctx, email)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error retrieving user for password reset: %s", err)
+		}
+		return
+	}
+
+	recent, err := cfg.db.CountRecentPasswordResetTokens(ctx, database.CountRecentPasswordResetTokensParams{
+		UserID: userDTO.ID,
+		CreatedAt: time.Now().Add(-passwordResetTTL),
+	})
+	if err != nil {
+		log.Printf("Error counting password reset tokens for user %s: %s", userDTO.ID, err)
+		return
+	}
+	if recent >= passwordResetLimit {
+		log.Printf("Password reset throttled for user %s", userDTO.ID)
+		return
+	}
+
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("Error creating password reset token: %s", err)
+		return
+	}
+
+	err = cfg.db.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		UserID: userDTO.ID,
+		TokenHash: auth.HashToken(token, cfg.refreshTokenKey),
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		log.Printf("Error saving password reset token for user %s: %s", userDTO.ID, err)
+		return
+	}
+
+	if err := cfg.mailer.Send(ctx, passwordResetMessage(userDTO.Email, cfg.passwordResetURL, token, passwordResetTTL)); err != nil {
+		log.Printf("Error sending password reset email to user %s: %s", userDTO.ID, err)
+	}
+}
+
+func (cfg *apiConfig) confirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+	if params.Token == "" || params.Password == "" {
+		respondWithError(w, 400, "Token and password are required")
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	userID, err := qtx.UsePasswordResetToken(r.Context(), auth.HashToken(params.Token, cfg.refreshTokenKey))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, 400, "Reset token is invalid or expired")
+			return
+		}
+		log.Printf("Error using password reset token: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	userDTO, err := qtx.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	// rejecting the password rolls back, so the token can be tried again
+	if !cfg.checkPassword(w, r, params.Password, userDTO.Email) {
+		return
+	}
+	hashedPassword, err := auth.HashPasswordWithParams(params.Password, cfg.passwordParams)
+	if err != nil {
+		log.Printf("Error hashing password: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		ID: userID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		log.Printf("Error updating password for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := qtx.DeletePasswordResetTokens(r.Context(), userID); err != nil {
+		log.Printf("Error deleting password reset tokens for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := qtx.ResetFailedLogins(r.Context(), userID); err != nil {
+		log.Printf("Error clearing failed logins for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if _, err := qtx.RevokeUserRefreshTokens(r.Context(), userID); err != nil {
+		log.Printf("Error revoking refresh tokens for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if _, err := qtx.BumpUserTokenVersion(r.Context(), userID); err != nil {
+		log.Printf("Error bumping token version for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing password reset: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+const emailVerificationTTL = 24 * time.Hour
+
+// emailVerificationLimit caps how many verification emails one account can
+// trigger per hour, counting the one sent on sign-up.
+const emailVerificationLimit = 3
+
+// sendEmailVerification stores a new verification token for the user and
+// mails the link in the background.
+func (cfg *apiConfig) sendEmailVerification(ctx context.Context, userDTO database.User) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.db.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		UserID: userDTO.ID,
+		TokenHash: auth.HashToken(token, cfg.refreshTokenKey),
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	cfg.deliver(verificationMessage(userDTO.Email, cfg.emailVerificationURL, token, emailVerificationTTL))
+	return nil
+}
+
+func (cfg *apiConfig) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, 400, "Token is required")
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	userID, err := qtx.UseEmailVerificationToken(r.Context(), auth.HashToken(token, cfg.refreshTokenKey))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, 400, "Verification token is invalid or expired")
+			return
+		}
+		log.Printf("Error using verification token: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	userDTO, err := qtx.MarkEmailVerified(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error verifying email of user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := qtx.DeleteEmailVerificationTokens(r.Context(), userID); err != nil {
+		log.Printf("Error deleting verification tokens for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing email verification: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, MapUserDTOToUser(userDTO))
+}
+
+func (cfg *apiConfig) resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	userDTO, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if userDTO.EmailVerifiedAt.Valid {
+		respondWithError(w, 409, "Email address is already verified")
+		return
+	}
+
+	recent, err := cfg.db.CountRecentEmailVerificationTokens(r.Context(), database.CountRecentEmailVerificationTokensParams{
+		UserID: userID,
+		CreatedAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		log.Printf("Error counting verification tokens for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if recent >= emailVerificationLimit {
+		w.Header().Set("Retry-After", "3600")
+		respondWithError(w, 429, "Too many verification emails, try again later")
+		return
+	}
+
+	if err := cfg.sendEmailVerification(r.Context(), userDTO); err != nil {
+		log.Printf("Error sending verification email to user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Wrong passwords lock the account with exponential backoff. An IP address
+// gets more room before it is blocked, since many users can share one.
+var (
+	accountLoginPolicy = throttle.Policy{FreeAttempts: 5, Base: time.Minute, Max: time.Hour, Window: 24 * time.Hour}
+	ipLoginPolicy = throttle.Policy{FreeAttempts: 20, Base: 30 * time.Second, Max: time.Hour, Window: 15 * time.Minute}
+)
+
+const accountUnlockTTL = 24 * time.Hour
+
+const (
+	eventLoginFailed = "login_failed"
+	eventAccountLocked = "account_locked"
+	eventAccountUnlocked = "account_unlocked"
+	eventLoginIPBlocked = "login_ip_blocked"
+	eventRefreshTokenReuse = "refresh_token_reuse"
+)
+
+// recordSecurityEvent stores an event for later review. Failing to store it
+// must not fail the request, so errors are only logged.
+func (cfg *apiConfig) recordSecurityEvent(r *http.Request, userID uuid.NullUUID, eventType, detail string) {
+	err := cfg.db.CreateSecurityEvent(r.Context(), database.CreateSecurityEventParams{
+		UserID: userID,
+		EventType: eventType,
+		IpAddress: clientIP(r, cfg.trustedProxies),
+		UserAgent: r.UserAgent(),
+		Detail: detail,
+	})
+	if err != nil {
+		log.Printf("Error recording %s security event: %s", eventType, err)
+	}
+}
+
+func accountLocked(userDTO database.User) bool {
+	return userDTO.LockedUntil.Valid && userDTO.LockedUntil.Time.After(time.Now())
+}
+
+// checkAccountLock responds with a 429 and returns false while the account
+// is locked.
+func (cfg *apiConfig) checkAccountLock(w http.ResponseWriter, userDTO database.User) bool {
+	if accountLocked(userDTO) {
+		respondTooManyRequests(w, userDTO.LockedUntil.Time, "Account is temporarily locked, check your email to unlock it")
+		return false
+	}
+	return true
+}
+
+// recordLoginFailure counts a failed login against the client IP and, when
+// the account is known, against the account, blocking either once it runs
+// out of free attempts.
+func (cfg *apiConfig) recordLoginFailure(r *http.Request, userDTO *database.User, reason string) {
+	ctx := r.Context()
+	now := time.Now()
+	ip := clientIP(r, cfg.trustedProxies)
+
+	userID := uuid.NullUUID{}
+	if userDTO != nil {
+		userID = uuid.NullUUID{UUID: userDTO.ID, Valid: true}
+	}
+	cfg.recordSecurityEvent(r, userID, eventLoginFailed, reason)
+
+	ipFailures, err := cfg.db.RecordLoginIPFailure(ctx, database.RecordLoginIPFailureParams{
+		IpAddress: ip,
+		Since: now.Add(-ipLoginPolicy.Window),
+	})
+	if err != nil {
+		log.Printf("Error recording failed login from %s: %s", ip, err)
+	} else if d := ipLoginPolicy.Backoff(int(ipFailures)); d > 0 {
+		err := cfg.db.BlockLoginIP(ctx, database.BlockLoginIPParams{
+			IpAddress: ip,
+			BlockedUntil: sql.NullTime{Time: now.Add(d), Valid: true},
+		})
+		if err != nil {
+			log.Printf("Error blocking logins from %s: %s", ip, err)
+		}
+		if ipLoginPolicy.Locks(int(ipFailures)) {
+			cfg.recordSecurityEvent(r, uuid.NullUUID{}, eventLoginIPBlocked, fmt.Sprintf("%d failed logins", ipFailures))
+		}
+	}
+
+	if userDTO == nil {
+		return
+	}
+
+	failures, err := cfg.db.RecordFailedLogin(ctx, database.RecordFailedLoginParams{
+		Since: now.Add(-accountLoginPolicy.Window),
+		ID: userDTO.ID,
+	})
+	if err != nil {
+		log.Printf("Error recording failed login for user %s: %s", userDTO.ID, err)
+		return
+	}
+	d := accountLoginPolicy.Backoff(int(failures))
+	if d == 0 {
+		return
+	}
+
+	err = cfg.db.LockUser(ctx, database.LockUserParams{
+		ID: userDTO.ID,
+		LockedUntil: sql.NullTime{Time: now.Add(d), Valid: true},
+	})
+	if err != nil {
+		log.Printf("Error locking user %s: %s", userDTO.ID, err)
+		return
+	}
+	if !accountLoginPolicy.Locks(int(failures)) {
+		return
+	}
+
+	cfg.recordSecurityEvent(r, userID, eventAccountLocked, fmt.Sprintf("%d failed logins", failures))
+	if err := cfg.sendAccountUnlock(ctx, *userDTO); err != nil {
+		log.Printf("Error sending unlock email to user %s: %s", userDTO.ID, err)
+	}
+}
+
+// clearLoginFailures resets the account's counter after a successful login.
+func (cfg *apiConfig) clearLoginFailures(ctx context.Context, userDTO database.User) {
+	if userDTO.FailedLoginCount == 0 && !userDTO.LockedUntil.Valid {
+		return
+	}
+	if err := cfg.db.ResetFailedLogins(ctx, userDTO.ID); err != nil {
+		log.Printf("Error clearing failed logins for user %s: %s", userDTO.ID, err)
+	}
+}
+
+// sendAccountUnlock stores a new unlock token for the user and mails the
+// link in the background.
+func (cfg *apiConfig) sendAccountUnlock(ctx context.Context, userDTO database.User) error {
+	token, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.db.CreateAccountUnlockToken(ctx, database.CreateAccountUnlockTokenParams{
+		UserID: userDTO.ID,
+		TokenHash: auth.HashToken(token, cfg.refreshTokenKey),
+		ExpiresAt: time.Now().Add(accountUnlockTTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	cfg.deliver(unlockMessage(userDTO.Email, cfg.accountUnlockURL, token, accountUnlockTTL))
+	return nil
+}
+
+func (cfg *apiConfig) unlockAccountHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		respondWithError(w, 400, "Token is required")
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	userID, err := qtx.UseAccountUnlockToken(r.Context(), auth.HashToken(token, cfg.refreshTokenKey))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			respondWithError(w, 400, "Unlock token is invalid or expired")
+			return
+		}
+		log.Printf("Error using unlock token: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := qtx.ResetFailedLogins(r.Context(), userID); err != nil {
+		log.Printf("Error unlocking user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := qtx.DeleteAccountUnlockTokens(r.Context(), userID); err != nil {
+		log.Printf("Error deleting unlock tokens for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing account unlock: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	cfg.recordSecurityEvent(r, uuid.NullUUID{UUID: userID, Valid: true}, eventAccountUnlocked, "unlock link")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkPassword applies the password policy and responds with a 422 listing
+// every violation when password is not acceptable.
+func (cfg *apiConfig) checkPassword(w http.ResponseWriter, r *http.Request, password, email string) bool {
+	violations, err := cfg.passwordPolicy.Check(r.Context(), password, email)
+	if err != nil {
+		log.Printf("Error checking password policy: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return false
+	}
+	if len(violations) > 0 {
+		respondWithJSON(w, 422, PasswordRejected{
+			Error: "Password does not meet the requirements",
+			Violations: violations,
+		})
+		return false
+	}
+	return true
+}
+
+// upgradePasswordHash rehashes a just verified password when its stored hash
+// was made with weaker parameters than the configured ones.
+func (cfg *apiConfig) upgradePasswordHash(ctx context.Context, userDTO database.User, password string) {
+	rehash, err := auth.NeedsRehash(userDTO.HashedPassword, cfg.passwordParams)
+	if err != nil {
+		log.Printf("Error decoding password hash of user %s: %s", userDTO.ID, err)
+		return
+	}
+	if !rehash {
+		return
+	}
+
+	hashedPassword, err := auth.HashPasswordWithParams(password, cfg.passwordParams)
+	if err != nil {
+		log.Printf("Error rehashing password of user %s: %s", userDTO.ID, err)
+		return
+	}
+	err = cfg.db.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{
+		ID: userDTO.ID,
+		HashedPassword: hashedPassword,
+	})
+	if err != nil {
+		log.Printf("Error saving rehashed password of user %s: %s", userDTO.ID, err)
+	}
+}
+
+func (cfg *apiConfig) getUserProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userDTO, err := cfg.db.GetUserByHandle(r.Context(), r.PathValue("handle"))
+	if err == sql.ErrNoRows {
+		respondWithError(w, 404, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error retrieving user by handle: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	respondWithJSON(w, 200, MapUserDTOToProfile(userDTO))
+}
+
+const maxAvatarSize = 2 << 20
+
+// uploadAvatarHandler takes the raw image as the request body.
+func (cfg *apiConfig) uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxAvatarSize))
+	if err != nil {
+		respondWithError(w, 413, fmt.Sprintf("Avatar must be at most %d MiB", maxAvatarSize >> 20))
+		return
+	}
+	ext, ok := avatarTypes[http.DetectContentType(data)]
+	if !ok {
+		respondWithError(w, 415, "Avatar must be a PNG, JPEG, GIF or WebP image")
+		return
+	}
+
+	userDTO, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	// a fresh key per upload lets the media route cache forever
+	key := fmt.Sprintf("avatars/%s/%s%s", userID, uuid.NewString(), ext)
+	if err := cfg.blobs.Put(r.Context(), key, bytes.NewReader(data)); err != nil {
+		log.Printf("Error storing avatar for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	updated, err := cfg.db.UpdateUserAvatar(r.Context(), database.UpdateUserAvatarParams{
+		ID: userID,
+		AvatarKey: sql.NullString{String: key, Valid: true},
+	})
+	if err != nil {
+		log.Printf("Error saving avatar for user %s: %s", userID, err)
+		cfg.deleteBlob(r.Context(), key)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if userDTO.AvatarKey.Valid {
+		cfg.deleteBlob(r.Context(), userDTO.AvatarKey.String)
+	}
+
+	respondWithJSON(w, 200, MapUserDTOToUser(updated))
+}
+
+func (cfg *apiConfig) deleteAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+
+	userDTO, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if !userDTO.AvatarKey.Valid {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	_, err = cfg.db.UpdateUserAvatar(r.Context(), database.UpdateUserAvatarParams{ID: userID})
+	if err != nil {
+		log.Printf("Error removing avatar for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	cfg.deleteBlob(r.Context(), userDTO.AvatarKey.String)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteBlob removes a blob that is no longer referenced. A failure only
+// leaves an orphaned file behind, so it is logged and otherwise ignored.
+func (cfg *apiConfig) deleteBlob(ctx context.Context, key string) {
+	if err := cfg.blobs.Delete(ctx, key); err != nil {
+		log.Printf("Error deleting blob %s: %s", key, err)
+	}
+}
+
+func (cfg *apiConfig) mediaHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	// exports are private and only served through their own route; the key
+	// must be clean so that "avatars/../exports/..." can't reach them
+	if path.Clean(key) != key || !strings.HasPrefix(key, "avatars/") {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	rc, err := cfg.blobs.Open(r.Context(), key)
+	if err == blob.ErrNotFound {
+		respondWithError(w, 404, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening blob %s: %s", key, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer rc.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, rc)
+}
+
+const eventAccountDeletionScheduled = "account_deletion_scheduled"
+const eventAccountRestored = "account_restored"
+
+// deleteAccountHandler soft-deletes the caller's account. It disappears from
+// public lookups and every session is ended right away, but nothing is removed
+// until the grace period has passed; signing in before then restores it.
+func (cfg *apiConfig) deleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Password string `json:"password"`
+	}
+
+	userID := requestPrincipal(r).UserID
+
+	params := parameters{}
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		log.Printf("Error decoding parameters: %s", err)
+		respondWithError(w, 400, "Bad request")
+		return
+	}
+
+	userDTO, err := cfg.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error retrieving user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if !cfg.checkAccountLock(w, userDTO) {
+		return
+	}
+	if flag, _ := auth.CheckPasswordHash(params.Password, userDTO.HashedPassword); !flag {
+		cfg.recordLoginFailure(r, &userDTO, "wrong password on account deletion")
+		respondWithError(w, 403, "Password is incorrect")
+		return
+	}
+
+	tx, err := cfg.conn.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("Error starting transaction: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	deleted, err := qtx.SoftDeleteUser(r.Context(), database.SoftDeleteUserParams{
+		ID: userID,
+		PurgeAfter: sql.NullTime{Time: time.Now().Add(cfg.accountDeletionGrace), Valid: true},
+	})
+	if err != nil {
+		log.Printf("Error scheduling deletion of user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if _, err := qtx.RevokeUserRefreshTokens(r.Context(), userID); err != nil {
+		log.Printf("Error revoking refresh tokens for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if _, err := qtx.BumpUserTokenVersion(r.Context(), userID); err != nil {
+		log.Printf("Error bumping token version for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing account deletion: %s", err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	cfg.recordSecurityEvent(r, uuid.NullUUID{UUID: userID, Valid: true}, eventAccountDeletionScheduled, "")
+	cfg.deliver(deletionMessage(deleted.Email, deleted.PurgeAfter.Time))
+
+	respondWithJSON(w, 202, AccountDeletion{DeletionScheduledFor: deleted.PurgeAfter.Time})
+}
+
+// restoreAccount cancels a pending deletion when its owner signs in again
+// during the grace period. It reports false once the grace period is over,
+// when the account only waits for the purge and can't be used anymore.
+func (cfg *apiConfig) restoreAccount(r *http.Request, userDTO database.User) (bool, error) {
+	if !userDTO.DeletedAt.Valid {
+		return true, nil
+	}
+	n, err := cfg.db.RestoreUser(r.Context(), userDTO.ID)
+	if err != nil || n == 0 {
+		return false, err
+	}
+	cfg.recordSecurityEvent(r, uuid.NullUUID{UUID: userDTO.ID, Valid: true}, eventAccountRestored, "signed in during grace period")
+	return true, nil
+}
+
+const purgeBatchSize = 100
+
+// purgeLoop removes accounts whose grace period has run out and data exports
+// past their expiry, once right away and then every interval.
+func (cfg *apiConfig) purgeLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		cfg.purge(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) purge(ctx context.Context) {
+	expiredKeys, err := cfg.db.DeleteExpiredDataExports(ctx)
+	if err != nil {
+		log.Printf("Error deleting expired data exports: %s", err)
+	}
+	for _, key := range expiredKeys {
+		if key.Valid {
+			cfg.deleteBlob(ctx, key.String)
+		}
+	}
+
+	for {
+		userDTOS, err := cfg.db.ListUsersToPurge(ctx, purgeBatchSize)
+		if err != nil {
+			log.Printf("Error listing users to purge: %s", err)
+			return
+		}
+		failed := 0
+		for _, userDTO := range userDTOS {
+			if err := cfg.purgeUser(ctx, userDTO); err != nil {
+				log.Printf("Error purging user %s: %s", userDTO.ID, err)
+				failed++
+			}
+		}
+		// a full batch of failures would be listed again, so leave those
+		// for the next run instead of retrying them in a loop
+		if len(userDTOS) < purgeBatchSize || failed == len(userDTOS) {
+			return
+		}
+	}
+}
+
+// purgeUser deletes the user for good. Rows that reference the user cascade
+// away, so only the counters kept on other users' chirps and the blobs need
+// cleaning up by hand.
+func (cfg *apiConfig) purgeUser(ctx context.Context, userDTO database.User) error {
+	exportKeys, err := cfg.db.ListDataExportKeys(ctx, userDTO.ID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := cfg.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	qtx := cfg.db.WithTx(tx)
+
+	if err := qtx.ReleaseUserLikes(ctx, userDTO.ID); err != nil {
+		return err
+	}
+	if err := qtx.ReleaseUserReactions(ctx, userDTO.ID); err != nil {
+		return err
+	}
+	if err := qtx.PruneReactionCounts(ctx); err != nil {
+		return err
+	}
+
+	chirpDTOS, err := qtx.ListChirpsForPurge(ctx, userDTO.ID)
+	if err != nil {
+		return err
+	}
+	referenced, err := qtx.ListChirpsReferencedByOthers(ctx, userDTO.ID)
+	if err != nil {
+		return err
+	}
+	drop, keep := purgedChirps(chirpDTOS, referenced)
+	if len(drop) > 0 {
+		if err := qtx.DeleteChirpsByIDs(ctx, drop); err != nil {
+			return err
+		}
+	}
+	// deleting the user detaches the tombstones from the account
+	for _, chirpID := range keep {
+		if err := qtx.TombstoneChirp(ctx, database.TombstoneChirpParams{
+			ID: chirpID,
+			UserID: userDTO.ID,
+		}); err != nil {
+			return err
+		}
+		if err := qtx.DeleteChirpRevisions(ctx, chirpID); err != nil {
+			return err
+		}
+		if err := clearChirpEntities(ctx, qtx, chirpID); err != nil {
+			return err
+		}
+	}
+
+	n, err := qtx.PurgeUser(ctx, userDTO.ID)
+	if err != nil {
+		return err
+	}
+	// restored since it was listed
+	if n == 0 {
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if userDTO.AvatarKey.Valid {
+		cfg.deleteBlob(ctx, userDTO.AvatarKey.String)
+	}
+	for _, key := range exportKeys {
+		if key.Valid {
+			cfg.deleteBlob(ctx, key.String)
+		}
+	}
+	log.Printf("Purged user %s", userDTO.ID)
+	return nil
+}
+
+const (
+	dataExportPending = "pending"
+	dataExportReady = "ready"
+	dataExportFailed = "failed"
+)
+
+// A user can request one export per cooldown. Finished archives can be
+// downloaded until they expire, and an export still pending after the
+// timeout is considered lost and no longer blocks a new one.
+const (
+	dataExportCooldown = time.Hour
+	dataExportTTL = 7 * 24 * time.Hour
+	dataExportTimeout = 10 * time.Minute
+)
+
+// requestDataExportHandler queues an archive of the caller's data and answers
+// before it is built; clients poll the returned export until it is ready.
+func (cfg *apiConfig) requestDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	userID := requestPrincipal(r).UserID
+	now := time.Now()
+
+	latest, err := cfg.db.GetLatestDataExport(r.Context(), userID)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error retrieving data exports of user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	if err == nil {
+		if latest.Status == dataExportPending && latest.CreatedAt.After(now.Add(-dataExportTimeout)) {
+			respondWithJSON(w, 202, MapDataExportDTOToDataExport(latest))
+			return
+		}
+		if latest.CreatedAt.After(now.Add(-dataExportCooldown)) {
+			respondTooManyRequests(w, latest.CreatedAt.Add(dataExportCooldown), "A data export was requested recently, try again later")
+			return
+		}
+	}
+
+	export, err := cfg.db.CreateDataExport(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error creating data export for user %s: %s", userID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+
+	go cfg.buildDataExport(export)
+
+	respondWithJSON(w, 202, MapDataExportDTOToDataExport(export))
+}
+
+// buildDataExport writes the archive for export to the blob store and marks
+// the export ready, or failed if anything goes wrong.
+func (cfg *apiConfig) buildDataExport(export database.DataExport) {
+	ctx, cancel := context.WithTimeout(context.Background(), dataExportTimeout)
+	defer cancel()
+
+	key := fmt.Sprintf("exports/%s/%s.json", export.UserID, export.ID)
+	err := cfg.writeDataExport(ctx, export.UserID, key)
+	if err != nil {
+		log.Printf("Error building data export %s: %s", export.ID, err)
+		if err := cfg.db.FailDataExport(ctx, export.ID); err != nil {
+			log.Printf("Error marking data export %s as failed: %s", export.ID, err)
+		}
+		return
+	}
+
+	err = cfg.db.CompleteDataExport(ctx, database.CompleteDataExportParams{
+		ID: export.ID,
+		BlobKey: sql.NullString{String: key, Valid: true},
+		ExpiresAt: sql.NullTime{Time: time.Now().Add(dataExportTTL), Valid: true},
+	})
+	if err != nil {
+		log.Printf("Error completing data export %s: %s", export.ID, err)
+		cfg.deleteBlob(ctx, key)
+	}
+}
+
+func (cfg *apiConfig) writeDataExport(ctx context.Context, userID uuid.UUID, key string) error {
+	userDTO, err := cfg.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	chirpDTOS, err := cfg.db.ListUserChirps(ctx, userID)
+	if err != nil {
+		return err
+	}
+	sessionDTOS, err := cfg.db.ListSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	archive := AccountArchive{
+		ExportedAt: time.Now(),
+		Profile: MapUserDTOToUser(userDTO),
+		Chirps: make([]Chirp, len(chirpDTOS)),
+		Sessions: make([]Session, len(sessionDTOS)),
+	}
+	for i, chirp := range chirpDTOS {
+		archive.Chirps[i] = MapChirpDTOToChirp(chirp)
+	}
+	for i, session := range sessionDTOS {
+		archive.Sessions[i] = MapSessionDTOToSession(session)
+	}
+
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		return err
+	}
+	return cfg.blobs.Put(ctx, key, bytes.NewReader(data))
+}
+
+// userDataExport loads the caller's export named in the path, responding with
+// a 404 for anyone else's.
+func (cfg *apiConfig) userDataExport(w http.ResponseWriter, r *http.Request) (database.DataExport, bool) {
+	userID := requestPrincipal(r).UserID
+
+	exportID, err := uuid.Parse(r.PathValue("export_id"))
+	if err != nil {
+		respondWithError(w, 404, "Export not found")
+		return database.DataExport{}, false
+	}
+
+	export, err := cfg.db.GetDataExport(r.Context(), database.GetDataExportParams{
+		ID: exportID,
+		UserID: userID,
+	})
+	if err == sql.ErrNoRows {
+		respondWithError(w, 404, "Export not found")
+		return database.DataExport{}, false
+	}
+	if err != nil {
+		log.Printf("Error retrieving data export %s: %s", exportID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return database.DataExport{}, false
+	}
+	return export, true
+}
+
+func (cfg *apiConfig) getDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	export, ok := cfg.userDataExport(w, r)
+	if !ok {
+		return
+	}
+	respondWithJSON(w, 200, MapDataExportDTOToDataExport(export))
+}
+
+func (cfg *apiConfig) downloadDataExportHandler(w http.ResponseWriter, r *http.Request) {
+	export, ok := cfg.userDataExport(w, r)
+	if !ok {
+		return
+	}
+	if export.Status != dataExportReady || !export.BlobKey.Valid {
+		respondWithError(w, 409, "Export is not ready")
+		return
+	}
+	if export.ExpiresAt.Valid && export.ExpiresAt.Time.Before(time.Now()) {
+		respondWithError(w, 410, "Export has expired")
+		return
+	}
+
+	rc, err := cfg.blobs.Open(r.Context(), export.BlobKey.String)
+	if err == blob.ErrNotFound {
+		respondWithError(w, 410, "Export has expired")
+		return
+	}
+	if err != nil {
+		log.Printf("Error opening data export %s: %s", export.ID, err)
+		respondWithError(w, 500, "Something went wrong")
+		return
+	}
+	defer rc.Close()
+
+	filename := fmt.Sprintf("chirpy-export-%s.json", export.CreatedAt.Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Cache-Control", "private, no-store")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, rc)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cvrs3d/webserv/internal/auth"
+	"github.com/cvrs3d/webserv/internal/blob"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	keys, err := auth.GenerateKeyring()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{keys: keys}
+
+	reached := false
+	next := func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		if _, ok := principalFromContext(r.Context()); ok {
+			t.Error("anonymous request should not carry a principal")
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	tests := []struct {
+		name          string
+		handler       http.HandlerFunc
+		authorization string
+		wantCode      int
+		wantChallenge string
+	}{
+		{
+			name:          "required without token",
+			handler:       cfg.middlewareRequireAuth(auth.ScopeChirpsWrite, next),
+			wantCode:      401,
+			wantChallenge: `Bearer realm="chirpy"`,
+		},
+		{
+			name:          "required with garbage token",
+			handler:       cfg.middlewareRequireAuth("", next),
+			authorization: "Bearer not-a-jwt",
+			wantCode:      401,
+			wantChallenge: `Bearer realm="chirpy", error="invalid_token"`,
+		},
+		{
+			name:          "required with wrong scheme",
+			handler:       cfg.middlewareRequireAuth("", next),
+			authorization: "Basic dXNlcjpwYXNz",
+			wantCode:      401,
+			wantChallenge: `Bearer realm="chirpy", error="invalid_token"`,
+		},
+		{
+			name:     "optional without token",
+			handler:  cfg.middlewareOptionalAuth(next),
+			wantCode: 200,
+		},
+		{
+			name:          "optional with garbage token",
+			handler:       cfg.middlewareOptionalAuth(next),
+			authorization: "Bearer not-a-jwt",
+			wantCode:      401,
+			wantChallenge: `Bearer realm="chirpy", error="invalid_token"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reached = false
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			tt.handler(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if got := rec.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf("WWW-Authenticate = %q, want %q", got, tt.wantChallenge)
+			}
+			if reached != (tt.wantCode == 200) {
+				t.Errorf("handler reached = %v", reached)
+			}
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	tests := []struct {
+		name     string
+		roles    []string
+		perm     auth.Permission
+		wantCode int
+	}{
+		{name: "user cannot view metrics", roles: []string{auth.RoleUser}, perm: auth.PermViewMetrics, wantCode: 403},
+		{name: "moderator can moderate", roles: []string{auth.RoleModerator}, perm: auth.PermModerate, wantCode: 200},
+		{name: "moderator cannot reset", roles: []string{auth.RoleModerator}, perm: auth.PermResetData, wantCode: 403},
+		{name: "admin can reset", roles: []string{auth.RoleAdmin}, perm: auth.PermResetData, wantCode: 200},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/admin/metrics", nil)
+			req = req.WithContext(context.WithValue(req.Context(), principalKey{}, auth.Principal{Roles: tt.roles}))
+			rec := httptest.NewRecorder()
+
+			if requirePermission(rec, req, tt.perm) {
+				rec.WriteHeader(http.StatusOK)
+			}
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestMediaHandler(t *testing.T) {
+	store := blob.FileStore{Dir: t.TempDir()}
+	if err := store.Put(context.Background(), "avatars/u1/a.png", strings.NewReader("png bytes")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), "exports/u1/e1.json", strings.NewReader("{}")); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &apiConfig{blobs: store}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /media/{key...}", cfg.mediaHandler)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/media/avatars/u1/a.png", nil))
+	if w.Code != 200 {
+		t.Fatalf("code = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", got)
+	}
+	if got := w.Body.String(); got != "png bytes" {
+		t.Errorf("body = %q", got)
+	}
+
+	for _, target := range []string{
+		"/media/avatars%2F..%2Fexports%2Fu1%2Fe1.json",
+		"/media/avatars/%2e%2e/exports/u1/e1.json",
+	} {
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code == 200 {
+			t.Errorf("%s: served a private blob", target)
+		}
+	}
+
+	for _, key := range []string{"avatars/u1/missing.png", "../../etc/passwd", "avatars/../../etc/passwd", "avatars/../exports/u1/e1.json", "exports/u1/e1.json"} {
+		r := httptest.NewRequest("GET", "/media/x", nil)
+		r.SetPathValue("key", key)
+		w = httptest.NewRecorder()
+		cfg.mediaHandler(w, r)
+		if w.Code != 404 {
+			t.Errorf("%s: code = %d, want 404", key, w.Code)
+		}
+	}
+}
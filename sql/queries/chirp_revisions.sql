@@ -0,0 +1,26 @@
+-- name: UpdateChirpBody :one
+WITH previous AS (
+    SELECT id, body, updated_at FROM chirps
+    WHERE id = sqlc.arg('id')
+    FOR UPDATE
+), revision AS (
+    INSERT INTO chirp_revisions (id, chirp_id, body, created_at, replaced_at)
+    SELECT gen_random_uuid(), previous.id, previous.body, previous.updated_at, NOW()
+    FROM previous
+)
+UPDATE chirps
+SET
+body = sqlc.arg('body'),
+updated_at = NOW()
+FROM previous
+WHERE chirps.id = previous.id
+RETURNING chirps.*;
+
+-- name: GetChirpRevisions :many
+SELECT * FROM chirp_revisions
+WHERE chirp_id=$1
+ORDER BY replaced_at DESC;
+
+-- name: DeleteChirpRevisions :exec
+DELETE FROM chirp_revisions
+WHERE chirp_id=$1;
@@ -1,27 +1,179 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
-VALUES (
-    gen_random_uuid(),
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, conversation_id, quote_of)
+SELECT
+    generated.id,
     NOW(),
     NOW(),
-    $1,
-    $2
-)
+    sqlc.arg('body'),
+    sqlc.arg('user_id')::uuid,
+    sqlc.narg('in_reply_to'),
+    COALESCE(parent.conversation_id, generated.id),
+    sqlc.narg('quote_of')
+FROM (SELECT gen_random_uuid() AS id) AS generated
+LEFT JOIN chirps parent ON parent.id = sqlc.narg('in_reply_to')
 RETURNING *;
 
--- name: GetChirps :many
-SELECT * FROM chirps 
-ORDER BY created_at ASC;
+-- name: CreateRechirp :one
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, conversation_id, rechirp_of)
+SELECT
+    generated.id,
+    NOW(),
+    NOW(),
+    '',
+    sqlc.arg('user_id')::uuid,
+    generated.id,
+    sqlc.arg('rechirp_of')::uuid
+FROM (SELECT gen_random_uuid() AS id) AS generated
+ON CONFLICT (user_id, rechirp_of) WHERE rechirp_of IS NOT NULL DO NOTHING
+RETURNING *;
 
--- name: GetChirpsByAuthor :many
+-- name: GetRechirp :one
 SELECT * FROM chirps
-WHERE user_id=$1
-ORDER BY created_at ASC;
+WHERE user_id = sqlc.arg('user_id')::uuid AND rechirp_of = sqlc.arg('rechirp_of')::uuid;
+
+-- name: DeleteRechirp :execrows
+DELETE FROM chirps
+WHERE user_id = sqlc.arg('user_id')::uuid AND rechirp_of = sqlc.arg('rechirp_of')::uuid;
+
+-- name: GetChirpsByIDs :many
+SELECT * FROM chirps
+WHERE id = ANY(sqlc.arg('ids')::uuid[])
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL);
+
+-- name: ListChirpsAsc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) > (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: ListChirpsDesc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+AND (sqlc.narg('author_id')::uuid IS NULL OR user_id = sqlc.narg('author_id'))
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (created_at, id) < (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetTimeline :many
+SELECT chirps.* FROM chirps
+JOIN follows ON follows.followee_id = chirps.user_id
+WHERE follows.follower_id = sqlc.arg('user_id')
+AND chirps.deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit');
 
 -- name: GetChirpByID :one
 SELECT * FROM chirps
 WHERE id=$1;
 
--- name: DeleteChirpByID :exec
+-- name: GetVisibleChirpByID :one
+SELECT * FROM chirps
+WHERE id=$1
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL);
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT parent.id, parent.in_reply_to, 1 AS depth
+    FROM chirps parent
+    WHERE parent.id = (SELECT child.in_reply_to FROM chirps child WHERE child.id = sqlc.arg('chirp_id'))
+    UNION ALL
+    SELECT parent.id, parent.in_reply_to, ancestors.depth + 1
+    FROM chirps parent
+    JOIN ancestors ON parent.id = ancestors.in_reply_to
+)
+SELECT chirps.* FROM chirps
+JOIN ancestors ON ancestors.id = chirps.id
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+ORDER BY ancestors.depth DESC;
+
+-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT child.id, 1 AS depth
+    FROM chirps child
+    WHERE child.in_reply_to = sqlc.arg('chirp_id')
+    UNION ALL
+    SELECT child.id, descendants.depth + 1
+    FROM chirps child
+    JOIN descendants ON child.in_reply_to = descendants.id
+)
+SELECT chirps.*, descendants.depth FROM chirps
+JOIN descendants ON descendants.id = chirps.id
+WHERE NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) > (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY chirps.created_at ASC, chirps.id ASC
+LIMIT sqlc.arg('page_limit');
+
+-- name: DeleteChirpByID :execrows
+DELETE FROM chirps
+WHERE id=$1 AND user_id=$2::uuid
+AND NOT EXISTS (
+    SELECT 1 FROM chirps ref
+    WHERE ref.in_reply_to=$1 OR ref.quote_of=$1 OR ref.rechirp_of=$1
+);
+
+-- name: TombstoneChirp :exec
+UPDATE chirps
+SET
+body='',
+deleted_at=NOW(),
+updated_at=NOW()
+WHERE id=$1 AND user_id=$2::uuid;
+
+-- name: SearchChirps :many
+SELECT chirps.*,
+    ts_rank_cd(chirps.search_vector, query)::real AS rank,
+    -- the body is HTML-escaped first, so the only markup in a snippet is <mark>
+    ts_headline(
+        'english',
+        replace(replace(replace(replace(replace(chirps.body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&quot;'), '''', '&#39;'),
+        query,
+        'StartSel=<mark>, StopSel=</mark>, MaxFragments=2'
+    ) AS snippet
+FROM chirps, to_tsquery('english', sqlc.arg('query')) query
+WHERE chirps.deleted_at IS NULL
+AND chirps.search_vector @@ query
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+AND (
+    sqlc.narg('cursor_rank')::real IS NULL
+    OR (ts_rank_cd(chirps.search_vector, query), chirps.created_at, chirps.id)
+        < (sqlc.narg('cursor_rank'), sqlc.narg('cursor_created_at')::timestamp, sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY rank DESC, chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: ListUserChirps :many
+SELECT * FROM chirps
+WHERE user_id = $1::uuid AND deleted_at IS NULL
+ORDER BY created_at, id;
+
+-- name: ListChirpsForPurge :many
+SELECT * FROM chirps
+WHERE user_id = $1::uuid;
+
+-- name: ListChirpsReferencedByOthers :many
+SELECT DISTINCT chirps.id FROM chirps
+JOIN chirps ref ON ref.in_reply_to = chirps.id OR ref.quote_of = chirps.id OR ref.rechirp_of = chirps.id
+WHERE chirps.user_id = $1::uuid
+AND ref.user_id IS DISTINCT FROM chirps.user_id;
+
+-- name: DeleteChirpsByIDs :exec
 DELETE FROM chirps
-WHERE id=$1 AND user_id=$2;
+WHERE id = ANY(sqlc.arg('ids')::uuid[]);
@@ -0,0 +1,47 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports (id, user_id, status, blob_key, created_at, completed_at, expires_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    'pending',
+    NULL,
+    NOW(),
+    NULL,
+    NULL
+)
+RETURNING *;
+
+-- name: GetLatestDataExport :one
+SELECT * FROM data_exports
+WHERE user_id = $1
+ORDER BY created_at DESC
+LIMIT 1;
+
+-- name: GetDataExport :one
+SELECT * FROM data_exports
+WHERE id = $1 AND user_id = $2;
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET
+status = 'ready',
+blob_key = $2,
+completed_at = NOW(),
+expires_at = $3
+WHERE id = $1;
+
+-- name: FailDataExport :exec
+UPDATE data_exports
+SET
+status = 'failed',
+completed_at = NOW()
+WHERE id = $1;
+
+-- name: ListDataExportKeys :many
+SELECT blob_key FROM data_exports
+WHERE user_id = $1 AND blob_key IS NOT NULL;
+
+-- name: DeleteExpiredDataExports :many
+DELETE FROM data_exports
+WHERE expires_at <= NOW()
+RETURNING blob_key;
@@ -0,0 +1,24 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens (id, user_id, token_hash, created_at, expires_at, used_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    NOW(),
+    $3,
+    NULL
+);
+
+-- name: CountRecentEmailVerificationTokens :one
+SELECT COUNT(*) FROM email_verification_tokens
+WHERE user_id = $1 AND created_at > $2;
+
+-- name: UseEmailVerificationToken :one
+UPDATE email_verification_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id;
+
+-- name: DeleteEmailVerificationTokens :exec
+DELETE FROM email_verification_tokens
+WHERE user_id = $1;
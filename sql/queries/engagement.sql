@@ -0,0 +1,83 @@
+-- name: LikeChirp :execrows
+INSERT INTO likes (chirp_id, user_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: UnlikeChirp :execrows
+DELETE FROM likes
+WHERE chirp_id=$1 AND user_id=$2;
+
+-- name: IncrementLikeCount :exec
+UPDATE chirps
+SET like_count = like_count + 1
+WHERE id=$1;
+
+-- name: DecrementLikeCount :exec
+UPDATE chirps
+SET like_count = like_count - 1
+WHERE id=$1;
+
+-- name: GetLikedChirpIDs :many
+SELECT chirp_id FROM likes
+WHERE user_id = sqlc.arg('user_id') AND chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[]);
+
+-- name: AddReaction :execrows
+INSERT INTO reactions (chirp_id, user_id, emoji, created_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: RemoveReaction :execrows
+DELETE FROM reactions
+WHERE chirp_id=$1 AND user_id=$2 AND emoji=$3;
+
+-- name: IncrementReactionCount :exec
+INSERT INTO reaction_counts (chirp_id, emoji, count)
+VALUES (
+    $1,
+    $2,
+    1
+)
+ON CONFLICT (chirp_id, emoji) DO UPDATE
+SET count = reaction_counts.count + 1;
+
+-- name: DecrementReactionCount :exec
+UPDATE reaction_counts
+SET count = count - 1
+WHERE chirp_id=$1 AND emoji=$2;
+
+-- name: DeleteEmptyReactionCounts :exec
+DELETE FROM reaction_counts
+WHERE chirp_id=$1 AND count <= 0;
+
+-- name: GetReactionCounts :many
+SELECT * FROM reaction_counts
+WHERE chirp_id = ANY(sqlc.arg('chirp_ids')::uuid[])
+ORDER BY chirp_id, count DESC, emoji;
+
+-- name: ReleaseUserLikes :exec
+UPDATE chirps
+SET like_count = like_count - 1
+WHERE id IN (SELECT chirp_id FROM likes WHERE likes.user_id = $1);
+
+-- name: ReleaseUserReactions :exec
+UPDATE reaction_counts
+SET count = reaction_counts.count - r.n
+FROM (
+    SELECT chirp_id, emoji, COUNT(*)::int AS n FROM reactions
+    WHERE reactions.user_id = $1
+    GROUP BY chirp_id, emoji
+) r
+WHERE reaction_counts.chirp_id = r.chirp_id AND reaction_counts.emoji = r.emoji;
+
+-- name: PruneReactionCounts :exec
+DELETE FROM reaction_counts
+WHERE count <= 0;
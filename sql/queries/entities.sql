@@ -0,0 +1,68 @@
+-- name: CreateHashtag :exec
+INSERT INTO hashtags (tag, created_at)
+VALUES (
+    $1,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: AddChirpHashtag :exec
+INSERT INTO chirp_hashtags (chirp_id, tag, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: AddChirpMention :exec
+INSERT INTO chirp_mentions (chirp_id, handle, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT DO NOTHING;
+
+-- name: AddChirpURL :exec
+INSERT INTO chirp_urls (chirp_id, position, url)
+VALUES (
+    $1,
+    $2,
+    $3
+);
+
+-- name: ListChirpsByHashtag :many
+SELECT chirps.* FROM chirps
+JOIN chirp_hashtags ON chirp_hashtags.chirp_id = chirps.id
+WHERE chirp_hashtags.tag = sqlc.arg('tag')
+AND chirps.deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+AND (
+    sqlc.narg('cursor_created_at')::timestamp IS NULL
+    OR (chirps.created_at, chirps.id) < (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid)
+)
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('page_limit');
+
+-- name: GetTrendingHashtags :many
+SELECT chirp_hashtags.tag, COUNT(*) AS uses FROM chirp_hashtags
+JOIN chirps ON chirps.id = chirp_hashtags.chirp_id
+WHERE chirp_hashtags.created_at > sqlc.arg('since')
+AND chirps.deleted_at IS NULL
+AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = chirps.user_id AND users.deleted_at IS NOT NULL)
+GROUP BY chirp_hashtags.tag
+ORDER BY uses DESC, chirp_hashtags.tag ASC
+LIMIT sqlc.arg('row_limit');
+
+-- name: DeleteChirpHashtags :exec
+DELETE FROM chirp_hashtags
+WHERE chirp_id=$1;
+
+-- name: DeleteChirpMentions :exec
+DELETE FROM chirp_mentions
+WHERE chirp_id=$1;
+
+-- name: DeleteChirpURLs :exec
+DELETE FROM chirp_urls
+WHERE chirp_id=$1;
@@ -0,0 +1,77 @@
+-- name: RecordFailedLogin :one
+UPDATE users
+SET
+failed_login_count = CASE
+    WHEN last_failed_login_at IS NULL OR last_failed_login_at < sqlc.arg('since')::timestamp THEN 1
+    ELSE failed_login_count + 1
+END,
+last_failed_login_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING failed_login_count;
+
+-- name: LockUser :exec
+UPDATE users
+SET locked_until = $2
+WHERE id = $1;
+
+-- name: ResetFailedLogins :exec
+UPDATE users
+SET
+failed_login_count = 0,
+last_failed_login_at = NULL,
+locked_until = NULL
+WHERE id = $1;
+
+-- name: RecordLoginIPFailure :one
+INSERT INTO login_ip_failures (ip_address, failures, last_failed_at, blocked_until)
+VALUES (sqlc.arg('ip_address'), 1, NOW(), NULL)
+ON CONFLICT (ip_address) DO UPDATE
+SET
+failures = CASE
+    WHEN login_ip_failures.last_failed_at < sqlc.arg('since')::timestamp THEN 1
+    ELSE login_ip_failures.failures + 1
+END,
+last_failed_at = NOW()
+RETURNING failures;
+
+-- name: BlockLoginIP :exec
+UPDATE login_ip_failures
+SET blocked_until = $2
+WHERE ip_address = $1;
+
+-- name: GetLoginIPBlockedUntil :one
+SELECT blocked_until FROM login_ip_failures
+WHERE ip_address = $1;
+
+-- name: CreateAccountUnlockToken :exec
+INSERT INTO account_unlock_tokens (id, user_id, token_hash, created_at, expires_at, used_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    NOW(),
+    $3,
+    NULL
+);
+
+-- name: UseAccountUnlockToken :one
+UPDATE account_unlock_tokens
+SET used_at = NOW()
+WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id;
+
+-- name: DeleteAccountUnlockTokens :exec
+DELETE FROM account_unlock_tokens
+WHERE user_id = $1;
+
+-- name: CreateSecurityEvent :exec
+INSERT INTO security_events (id, user_id, event_type, ip_address, user_agent, detail, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW()
+);
@@ -0,0 +1,49 @@
+-- name: SetTOTPSecret :exec
+UPDATE users
+SET
+totp_secret = $2,
+totp_enabled_at = NULL,
+totp_last_step = NULL,
+updated_at = NOW()
+WHERE id = $1;
+
+-- name: EnableTOTP :exec
+UPDATE users
+SET
+totp_enabled_at = NOW(),
+totp_last_step = $2,
+updated_at = NOW()
+WHERE id = $1;
+
+-- name: DisableTOTP :exec
+UPDATE users
+SET
+totp_secret = NULL,
+totp_enabled_at = NULL,
+totp_last_step = NULL,
+updated_at = NOW()
+WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+UPDATE users
+SET totp_last_step = sqlc.arg('step')::bigint
+WHERE id = sqlc.arg('id') AND (totp_last_step IS NULL OR totp_last_step < sqlc.arg('step')::bigint);
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO mfa_recovery_codes (id, user_id, code_hash, created_at, used_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    NOW(),
+    NULL
+);
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM mfa_recovery_codes
+WHERE user_id = $1;
+
+-- name: UseRecoveryCode :execrows
+UPDATE mfa_recovery_codes
+SET used_at = NOW()
+WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL;
@@ -0,0 +1,48 @@
+-- name: ListModerationRules :many
+SELECT * FROM moderation_rules
+ORDER BY pattern ASC;
+
+-- name: UpsertModerationRule :one
+INSERT INTO moderation_rules (id, pattern, action, created_at, updated_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    NOW(),
+    NOW()
+)
+ON CONFLICT (pattern) DO UPDATE
+SET
+action = EXCLUDED.action,
+updated_at = NOW()
+RETURNING *;
+
+-- name: DeleteModerationRule :execrows
+DELETE FROM moderation_rules
+WHERE id=$1;
+
+-- name: CreateHeldChirp :one
+INSERT INTO held_chirps (id, user_id, body, in_reply_to, quote_of, matched, created_at)
+VALUES (
+    gen_random_uuid(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW()
+)
+RETURNING *;
+
+-- name: ListHeldChirps :many
+SELECT * FROM held_chirps
+ORDER BY created_at ASC;
+
+-- name: TakeHeldChirp :one
+DELETE FROM held_chirps
+WHERE id=$1
+RETURNING *;
+
+-- name: DeleteHeldChirp :execrows
+DELETE FROM held_chirps
+WHERE id=$1;
@@ -1,22 +1,81 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens (token, created_at, updated_at, user_id, expires_at, revoked_at)
+INSERT INTO refresh_tokens (id, lookup_prefix, token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, user_agent, ip_address, last_used_at, scope)
 VALUES (
     $1,
-    NOW(),
-    NOW(),
     $2,
     $3,
-    $4
+    NOW(),
+    NOW(),
+    $4,
+    $5,
+    $6,
+    $7,
+    $8,
+    $9,
+    NOW(),
+    $10
 )
 RETURNING *;
 
 -- name: GetRefreshTokenByToken :one
 SELECT * FROM refresh_tokens
-WHERE token = $1 AND expires_at > NOW() AND revoked_at is NULL;
+WHERE lookup_prefix = $1 AND token_hash = $2 AND expires_at > NOW() AND revoked_at is NULL;
 
 -- name: UpdateRefreshToken :exec
 UPDATE refresh_tokens 
 SET 
 revoked_at = NOW(),
 updated_at = NOW()
-WHERE token=$1;
\ No newline at end of file
+WHERE lookup_prefix = $1 AND token_hash = $2;
+
+-- name: GetAnyRefreshToken :one
+SELECT * FROM refresh_tokens
+WHERE lookup_prefix = $1 AND token_hash = $2;
+
+-- name: RotateRefreshToken :one
+UPDATE refresh_tokens
+SET
+revoked_at = NOW(),
+updated_at = NOW(),
+replaced_by = sqlc.arg('replaced_by')::uuid
+WHERE lookup_prefix = sqlc.arg('lookup_prefix') AND token_hash = sqlc.arg('token_hash') AND expires_at > NOW() AND revoked_at IS NULL
+RETURNING *;
+
+-- name: RevokeRefreshTokenFamily :execrows
+UPDATE refresh_tokens
+SET
+revoked_at = NOW(),
+updated_at = NOW()
+WHERE family_id = $1 AND revoked_at IS NULL;
+
+-- name: ListSessions :many
+SELECT
+    t.family_id,
+    t.user_agent,
+    t.ip_address,
+    t.last_used_at,
+    (SELECT MIN(f.created_at) FROM refresh_tokens f WHERE f.family_id = t.family_id)::timestamp AS signed_in_at
+FROM refresh_tokens t
+WHERE t.user_id = $1 AND t.revoked_at IS NULL AND t.expires_at > NOW()
+ORDER BY t.last_used_at DESC;
+
+-- name: RevokeSession :execrows
+UPDATE refresh_tokens
+SET
+revoked_at = NOW(),
+updated_at = NOW()
+WHERE family_id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: RevokeUserRefreshTokens :execrows
+UPDATE refresh_tokens
+SET
+revoked_at = NOW(),
+updated_at = NOW()
+WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeOtherSessions :execrows
+UPDATE refresh_tokens
+SET
+revoked_at = NOW(),
+updated_at = NOW()
+WHERE user_id = $1 AND family_id <> $2 AND revoked_at IS NULL;
@@ -1,11 +1,12 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, hashed_password)
+INSERT INTO users (id, created_at, updated_at, email, hashed_password, handle)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    $2,
+    $3
 )
 RETURNING *;
 
@@ -29,4 +30,105 @@ UPDATE users
 SET 
 is_chirpy_red=TRUE,
 updated_at=NOW()
-WHERE id=$1;
\ No newline at end of file
+WHERE id=$1;
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id=$1;
+
+-- name: GetUserTokenVersion :one
+SELECT token_version FROM users
+WHERE id=$1;
+
+-- name: BumpUserTokenVersion :one
+UPDATE users
+SET
+token_version = token_version + 1,
+updated_at = NOW()
+WHERE id=$1
+RETURNING token_version;
+
+-- name: UpdateUserRole :one
+UPDATE users
+SET
+role = $2,
+token_version = token_version + 1,
+updated_at = NOW()
+WHERE id=$1
+RETURNING *;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET
+hashed_password = $2,
+updated_at = NOW()
+WHERE id = $1;
+
+-- name: MarkEmailVerified :one
+UPDATE users
+SET
+email_verified_at = NOW(),
+updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateUserEmail :one
+UPDATE users
+SET
+email = $2,
+email_verified_at = NULL,
+updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetUserByHandle :one
+SELECT * FROM users
+WHERE lower(handle) = lower($1) AND deleted_at IS NULL;
+
+-- name: GetUsersByIDs :many
+SELECT * FROM users
+WHERE id = ANY(sqlc.arg('ids')::uuid[]) AND deleted_at IS NULL;
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET
+handle = $2,
+display_name = $3,
+bio = $4,
+updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: UpdateUserAvatar :one
+UPDATE users
+SET
+avatar_key = $2,
+updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SoftDeleteUser :one
+UPDATE users
+SET
+deleted_at = NOW(),
+purge_after = $2,
+updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: RestoreUser :execrows
+UPDATE users
+SET
+deleted_at = NULL,
+purge_after = NULL,
+updated_at = NOW()
+WHERE id = $1 AND (purge_after IS NULL OR purge_after > NOW());
+
+-- name: ListUsersToPurge :many
+SELECT * FROM users
+WHERE purge_after <= NOW()
+ORDER BY purge_after
+LIMIT $1;
+
+-- name: PurgeUser :execrows
+DELETE FROM users
+WHERE id = $1 AND purge_after <= NOW();
@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE chirps
+ADD COLUMN in_reply_to UUID REFERENCES chirps(id) ON DELETE SET NULL,
+ADD COLUMN conversation_id UUID,
+ADD COLUMN deleted_at TIMESTAMP;
+
+UPDATE chirps SET conversation_id = id;
+
+ALTER TABLE chirps
+ALTER COLUMN conversation_id SET NOT NULL;
+
+CREATE INDEX chirps_in_reply_to_idx ON chirps (in_reply_to);
+CREATE INDEX chirps_conversation_id_idx ON chirps (conversation_id);
+
+-- +goose Down
+DROP INDEX chirps_conversation_id_idx;
+DROP INDEX chirps_in_reply_to_idx;
+
+ALTER TABLE chirps
+DROP COLUMN deleted_at,
+DROP COLUMN conversation_id,
+DROP COLUMN in_reply_to;
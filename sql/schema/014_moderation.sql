@@ -0,0 +1,31 @@
+-- +goose Up
+CREATE TABLE moderation_rules (
+    id UUID PRIMARY KEY,
+    pattern TEXT UNIQUE NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('mask', 'hold', 'reject')),
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO moderation_rules (id, pattern, action, created_at, updated_at)
+VALUES
+    (gen_random_uuid(), 'kerfuffle', 'mask', NOW(), NOW()),
+    (gen_random_uuid(), 'sharbert', 'mask', NOW(), NOW()),
+    (gen_random_uuid(), 'fornax', 'mask', NOW(), NOW());
+
+CREATE TABLE held_chirps (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    body TEXT NOT NULL,
+    in_reply_to UUID,
+    quote_of UUID,
+    matched TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX held_chirps_created_at_idx ON held_chirps (created_at);
+
+-- +goose Down
+DROP TABLE held_chirps;
+DROP TABLE moderation_rules;
@@ -0,0 +1,25 @@
+-- +goose Up
+-- Existing rows hold plaintext tokens and the hash key isn't available to
+-- SQL, so they can't be rehashed: every session has to log in again.
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens DROP CONSTRAINT refresh_tokens_pkey;
+ALTER TABLE refresh_tokens DROP COLUMN token;
+ALTER TABLE refresh_tokens DROP COLUMN replaced_by;
+ALTER TABLE refresh_tokens ADD COLUMN id UUID PRIMARY KEY;
+ALTER TABLE refresh_tokens ADD COLUMN lookup_prefix TEXT NOT NULL;
+ALTER TABLE refresh_tokens ADD COLUMN token_hash TEXT NOT NULL;
+ALTER TABLE refresh_tokens ADD COLUMN replaced_by UUID;
+
+CREATE INDEX refresh_tokens_lookup_prefix_idx ON refresh_tokens (lookup_prefix);
+
+-- +goose Down
+DELETE FROM refresh_tokens;
+
+DROP INDEX refresh_tokens_lookup_prefix_idx;
+ALTER TABLE refresh_tokens DROP COLUMN replaced_by;
+ALTER TABLE refresh_tokens DROP COLUMN token_hash;
+ALTER TABLE refresh_tokens DROP COLUMN lookup_prefix;
+ALTER TABLE refresh_tokens DROP COLUMN id;
+ALTER TABLE refresh_tokens ADD COLUMN token TEXT PRIMARY KEY;
+ALTER TABLE refresh_tokens ADD COLUMN replaced_by TEXT;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- tokens issued before scopes existed keep full access
+ALTER TABLE refresh_tokens ADD COLUMN scope TEXT NOT NULL DEFAULT 'chirps:read chirps:write social:write account:read account:write';
+ALTER TABLE refresh_tokens ALTER COLUMN scope DROP DEFAULT;
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN scope;
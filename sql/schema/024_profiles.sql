@@ -0,0 +1,19 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN handle TEXT;
+ALTER TABLE users ADD COLUMN display_name TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN avatar_key TEXT;
+
+-- existing accounts get a placeholder handle they can change later
+UPDATE users SET handle = 'user_' || substr(md5(id::text), 1, 10);
+ALTER TABLE users ALTER COLUMN handle SET NOT NULL;
+
+CREATE UNIQUE INDEX users_handle_lower_idx ON users (lower(handle));
+
+-- +goose Down
+DROP INDEX users_handle_lower_idx;
+
+ALTER TABLE users DROP COLUMN avatar_key;
+ALTER TABLE users DROP COLUMN bio;
+ALTER TABLE users DROP COLUMN display_name;
+ALTER TABLE users DROP COLUMN handle;
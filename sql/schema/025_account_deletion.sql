@@ -0,0 +1,26 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN purge_after TIMESTAMP;
+
+CREATE INDEX users_purge_after_idx ON users (purge_after) WHERE purge_after IS NOT NULL;
+
+CREATE TABLE data_exports (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'ready', 'failed')),
+    blob_key TEXT,
+    created_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX data_exports_user_id_created_at_idx ON data_exports (user_id, created_at DESC);
+
+-- +goose Down
+DROP TABLE data_exports;
+
+DROP INDEX users_purge_after_idx;
+
+ALTER TABLE users DROP COLUMN purge_after;
+ALTER TABLE users DROP COLUMN deleted_at;
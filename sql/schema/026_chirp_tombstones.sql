@@ -0,0 +1,15 @@
+-- +goose Up
+ALTER TABLE chirps ALTER COLUMN user_id DROP NOT NULL;
+
+ALTER TABLE chirps
+DROP CONSTRAINT chirps_user_id_fkey,
+ADD CONSTRAINT chirps_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL;
+
+-- +goose Down
+DELETE FROM chirps WHERE user_id IS NULL;
+
+ALTER TABLE chirps
+DROP CONSTRAINT chirps_user_id_fkey,
+ADD CONSTRAINT chirps_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE chirps ALTER COLUMN user_id SET NOT NULL;